@@ -1,25 +1,45 @@
 package guest
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/shellhazard/incus-guestapi/incus"
-	"nhooyr.io/websocket"
 )
 
 // The API is documented here: https://linuxcontainers.org/incus/docs/main/dev-incus/
 
+// The first segment of each path is taken as the host when building
+// request URLs, so the request paths are the remaining segments.
 const (
 	SocketPath = "/dev/incus/sock"
 
+	// LXDSocketPath is the path of the equivalent socket
+	// provided to LXD instances. See WithLXDCompat.
+	LXDSocketPath = "/dev/lxd/sock"
+
+	// DefaultMaxResponseBytes is the default limit on the size
+	// of a response body read by the client.
+	DefaultMaxResponseBytes = 4 << 20
+
+	RootPath         = "/1.0"
 	InstanceInfoPath = "/1.0/1.0"
 	ListDevicesPath  = "/1.0/1.0/devices"
 	ConfigPath       = "/1.0/1.0/config"
@@ -27,65 +47,469 @@ const (
 	EventsPath       = "/1.0/1.0/events"
 )
 
-var UnexpectedStatusCode = errors.New("unexpected status code")
+// insideInstanceTimeout bounds the connection attempt made by
+// IsInsideInstance and IsInsideInstanceWith.
+const insideInstanceTimeout = 2 * time.Second
+
+// IsInsideInstance attempts to connect to /dev/incus/sock, or the
+// socket at path if one is provided. The attempt is abandoned after
+// a couple of seconds; use IsInsideInstanceContext to choose a limit.
+func IsInsideInstance(path ...string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), insideInstanceTimeout)
+	defer cancel()
+
+	return IsInsideInstanceContext(ctx, path...)
+}
 
-// IsIncus attempts to connect to /dev/incus/sock.
-func IsInsideInstance() bool {
-	addr, err := net.ResolveUnixAddr("unix", SocketPath)
+// IsInsideInstanceContext is like IsInsideInstance, but the attempt
+// is bounded only by ctx.
+func IsInsideInstanceContext(ctx context.Context, path ...string) bool {
+	socketPath := SocketPath
+	if len(path) > 0 && path[0] != "" {
+		socketPath = path[0]
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
 	if err != nil {
 		return false
 	}
 
-	conn, err := net.DialUnix("unix", nil, addr)
+	// Successful. We can close out here.
+	conn.Close()
+
+	return true
+}
+
+// IsInsideInstanceWith is like IsInsideInstance, but connects
+// using dial, as set on a client with WithDialer.
+func IsInsideInstanceWith(dial Dialer) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), insideInstanceTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx)
 	if err != nil {
 		return false
 	}
 
-	// Successful. We can close out here.
 	conn.Close()
 
 	return true
 }
 
+// classifyDialError wraps err with ErrNotInsideInstance if it
+// shows the socket is missing or nothing is listening on it.
+func classifyDialError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%w: %w", ErrNotInsideInstance, err)
+	}
+
+	return err
+}
+
+// GuestAPI is the set of guest API operations provided by GuestClient.
+// Accept it instead of *GuestClient to substitute a fake in tests.
+type GuestAPI interface {
+	Info() (*incus.InstanceInfo, error)
+	Config(key string) (string, error)
+	HasConfig(key string) (bool, error)
+	Devices() (map[string]map[string]string, error)
+	ListConfig() ([]string, error)
+	MetaData() (string, error)
+	ListenForEvents(ctx context.Context, callback func(*incus.Event), events ...incus.EventType) error
+}
+
+var _ GuestAPI = (*GuestClient)(nil)
+
+// GuestClient is a client for the guest API. It's safe for concurrent
+// use by multiple goroutines, including any number of event listeners,
+// and a single client should be shared rather than creating one per
+// call, so that connections are reused.
+//
+// Options are applied once by NewClient. Any state the client updates
+// afterwards, such as its cache, circuit breaker and event counters, is
+// synchronised internally.
 type GuestClient struct {
-	c *http.Client
+	c    *http.Client
+	base http.RoundTripper
+
+	socketPath       string
+	dialer           Dialer
+	timeout          time.Duration
+	dialTimeout      time.Duration
+	logger           *slog.Logger
+	configPrefix     string
+	maxResponseBytes int64
+	middleware       []Middleware
+	retryAttempts    int
+	breaker          *breaker
+	lxdCompat        bool
+
+	reconnect        bool
+	maxReconnects    int
+	reconnectBackoff time.Duration
+	onReconnect      func()
+	onConnect        func()
+	onDisconnect     func(error)
+	onSubscribe      func([]incus.EventType)
+
+	concurrentCallbacks bool
+	propagatePanics     bool
+
+	eventBuffer   int
+	bufferPolicy  BufferPolicy
+	droppedEvents atomic.Uint64
+
+	eventFilters  []func(*incus.Event) bool
+	pingInterval  time.Duration
+	skipBadEvents bool
+	onBadEvent    func([]byte, error)
+
+	lastEventMu sync.Mutex
+	lastEvent   *incus.Event
+	lastEventAt time.Time
+
+	dedupWindow      time.Duration
+	suppressedEvents atomic.Uint64
+
+	cache           *cache
+	strictDecoding  bool
+	emptyAsNotFound bool
+
+	tracer  Tracer
+	metrics Metrics
+
+	closed atomic.Bool
+}
+
+// NewClient returns a client for the guest API, configured
+// by the provided options.
+func NewClient(opts ...Option) *GuestClient {
+	g := &GuestClient{
+		socketPath:       SocketPath,
+		logger:           slog.New(discardHandler{}),
+		configPrefix:     "user.",
+		maxResponseBytes: DefaultMaxResponseBytes,
+		reconnectBackoff: defaultReconnectBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	// Copy the client so we don't modify one owned by the caller.
+	c := &http.Client{}
+	if g.c != nil {
+		*c = *g.c
+	}
+
+	switch t := c.Transport.(type) {
+	case nil:
+		c.Transport = &http.Transport{
+			DialContext: g.dialContext,
+		}
+	case *http.Transport:
+		if t.DialContext == nil {
+			t = t.Clone()
+			t.DialContext = g.dialContext
+			c.Transport = t
+		}
+	}
+
+	// Wrap the transport so the first middleware is outermost,
+	// keeping the one that dials the socket innermost.
+	g.base = c.Transport
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		c.Transport = g.middleware[i](c.Transport)
+	}
+
+	if g.timeout > 0 {
+		c.Timeout = g.timeout
+	}
+
+	g.c = c
+
+	return g
+}
+
+// Close releases any idle connections held by the client. Using
+// the client afterwards returns ErrClientClosed.
+func (g *GuestClient) Close() error {
+	g.closed.Store(true)
+	g.c.CloseIdleConnections()
+
+	// Middleware may hide the underlying transport from the client.
+	if t, ok := g.base.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+
+	return nil
 }
 
-func NewClient() *GuestClient {
-	return &GuestClient{
-		c: &http.Client{
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-					dialer := net.Dialer{}
-					return dialer.DialContext(ctx, "unix", SocketPath)
-				},
-			},
-		},
+func (g *GuestClient) dialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	if g.dialer != nil {
+		if g.dialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, g.dialTimeout)
+			defer cancel()
+		}
+
+		return g.dialer(ctx)
+	}
+
+	dialer := net.Dialer{Timeout: g.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", g.socketPath)
+	if err != nil && g.lxdCompat && g.socketPath != LXDSocketPath {
+		lxdConn, lxdErr := dialer.DialContext(ctx, "unix", LXDSocketPath)
+		if lxdErr == nil {
+			return lxdConn, nil
+		}
 	}
+
+	return conn, err
+}
+
+// DoRequest issues a request against the guest API for the given
+// path, such as "/1.0/devices", and returns the raw response. If
+// body is non-nil, it's sent as JSON. The caller must close the
+// response body.
+//
+// DoRequest is intended for endpoints the client doesn't cover
+// yet. It's not considered stable and may change in future.
+func (g *GuestClient) DoRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	return g.do(ctx, method, body, RootPath, path)
 }
 
-func handlejson[T any](gapi *GuestClient, path string, target T) (T, error) {
-	endpoint, err := url.JoinPath("http://", path)
+// get issues a GET request against the socket for the given path.
+func (g *GuestClient) get(ctx context.Context, path ...string) (*http.Response, error) {
+	return g.do(ctx, http.MethodGet, nil, path...)
+}
+
+// do issues a request against the socket for the given path. If
+// body is non-nil, it's sent as JSON.
+func (g *GuestClient) do(ctx context.Context, method string, body io.Reader, path ...string) (*http.Response, error) {
+	req, err := g.newRequest(ctx, method, body, path...)
 	if err != nil {
-		return target, fmt.Errorf("unexpected error: %w", err)
+		return nil, err
 	}
 
-	resp, err := gapi.c.Get(endpoint)
+	return g.send(req)
+}
+
+// newRequest builds a request against the socket for the given
+// path. If body is non-nil, it's sent as JSON.
+func (g *GuestClient) newRequest(ctx context.Context, method string, body io.Reader, path ...string) (*http.Request, error) {
+	endpoint, err := url.JoinPath("http://", path...)
 	if err != nil {
-		return target, fmt.Errorf("socket error: %w", err)
+		return nil, fmt.Errorf("unexpected error: %w", err)
+	}
+
+	return g.newRequestURL(ctx, method, endpoint, body)
+}
+
+// newRequestURL is like newRequest, but takes the full URL.
+func (g *GuestClient) newRequestURL(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	if g.closed.Load() {
+		return nil, ErrClientClosed
 	}
-	defer resp.Body.Close()
 
-	payload, err := io.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
 	if err != nil {
-		return target, fmt.Errorf("reader error: %w", err)
+		return nil, fmt.Errorf("unexpected error: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// send sends req, recording a span for it if the client has a
+// tracer, and its outcome if the client has metrics.
+func (g *GuestClient) send(req *http.Request) (resp *http.Response, err error) {
+	if g.metrics != nil {
+		start := time.Now()
+		defer func() {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			g.metrics.RequestDone(req.Method, route(req.URL.Path), status, time.Since(start), err)
+		}()
+	}
+
+	if g.tracer == nil {
+		return g.sendRetrying(req)
 	}
 
+	ctx, span := g.tracer.Start(req.Context(), "guest "+req.Method)
+	defer span.End()
+
+	span.SetAttribute(attrMethod, req.Method)
+	span.SetAttribute(attrPath, req.URL.Path)
+
+	resp, err = g.sendRetrying(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttribute(attrStatusCode, resp.StatusCode)
+
+	return resp, nil
+}
+
+// sendRetrying sends req, retrying according to the client's retry policy.
+func (g *GuestClient) sendRetrying(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if g.breaker != nil && !g.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := g.c.Do(req)
+
+		// Only failures to reach the socket count towards opening the
+		// circuit, not the caller giving up on the request.
+		if g.breaker != nil {
+			if err != nil && req.Context().Err() != nil {
+				g.breaker.abandon()
+			} else {
+				g.breaker.record(err != nil)
+			}
+		}
+
+		if err != nil {
+			g.logger.Debug("guest api request failed", "method", req.Method, "path", req.URL.Path, "error", err)
+			return nil, fmt.Errorf("socket error: %w", classifyDialError(err))
+		}
+
+		g.logger.Debug("guest api request", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode)
+
+		if attempt >= g.retryAttempts || !shouldRetry(req, resp) {
+			return decompress(resp)
+		}
+
+		delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			delay = retryBackoff << min(attempt-1, 6)
+		}
+		delay = min(delay, maxRetryDelay)
+
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		g.logger.Debug("retrying guest api request", "path", req.URL.Path, "attempt", attempt, "delay", delay)
+
+		select {
+		case <-req.Context().Done():
+			return nil, fmt.Errorf("socket error: %w", req.Context().Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// decompress replaces the body of resp with a decompressing reader if
+// it's gzipped. The transport normally does this itself, but not when
+// compression is disabled or a middleware sets Accept-Encoding.
+func decompress(resp *http.Response) (*http.Response, error) {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp, nil
+	}
+
+	// A HEAD response, or one with no content, has no body to decode.
+	if resp.Request.Method == http.MethodHead || resp.ContentLength == 0 {
+		return resp, nil
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("reader error: %w", err)
+	}
+
+	resp.Body = &gzipBody{Reader: zr, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return resp, nil
+}
+
+// gzipBody decompresses a response body, closing the
+// underlying body when closed.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// body returns the body of resp, limited to the client's
+// response size limit.
+func (g *GuestClient) body(resp *http.Response) io.Reader {
+	if g.maxResponseBytes <= 0 {
+		return resp.Body
+	}
+
+	return &limitedReader{r: resp.Body, limit: g.maxResponseBytes}
+}
+
+// readBody reads the body of resp, failing if it's larger than
+// the client's response size limit.
+func (g *GuestClient) readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(g.body(resp))
+	if err != nil {
+		return nil, fmt.Errorf("reader error: %w", err)
+	}
+
+	return body, nil
+}
+
+// limitedReader is like io.LimitedReader, but fails with
+// ErrResponseTooLarge if there's more to read past the limit.
+type limitedReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if remaining := l.limit + 1 - l.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	if l.read > l.limit {
+		return n, fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, l.limit)
+	}
+
+	return n, err
+}
+
+func handlejson[T any](ctx context.Context, gapi *GuestClient, path string, target T) (T, error) {
+	resp, err := gapi.get(ctx, path)
+	if err != nil {
+		return target, err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
-		return target, fmt.Errorf("%w: %d", UnexpectedStatusCode, resp.StatusCode)
+		return target, gapi.newAPIError(resp)
+	}
+
+	dec := json.NewDecoder(gapi.body(resp))
+	if gapi.strictDecoding {
+		dec.DisallowUnknownFields()
 	}
 
-	err = json.Unmarshal(payload, &target)
+	err = dec.Decode(&target)
 	if err != nil {
 		return target, fmt.Errorf("unmarshal error: %w", err)
 	}
@@ -93,68 +517,212 @@ func handlejson[T any](gapi *GuestClient, path string, target T) (T, error) {
 	return target, nil
 }
 
+// Get issues a GET request against the guest API for the given path,
+// such as "/1.0/devices", and decodes the JSON response into a value
+// of type T. Errors are the same as those returned by other methods.
+//
+// Like DoRequest, it's intended for endpoints the client doesn't
+// cover yet.
+func Get[T any](g *GuestClient, ctx context.Context, path string) (T, error) {
+	var target T
+	return handlejson[T](ctx, g, RootPath+"/"+strings.TrimPrefix(path, "/"), target)
+}
+
+// Ping checks that the guest API is responding to requests,
+// returning nil if it is.
+func (g *GuestClient) Ping(ctx context.Context) error {
+	resp, err := g.get(ctx, InstanceInfoPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return g.newAPIError(resp)
+	}
+
+	return nil
+}
+
 // Info returns information about the API and instance state.
 //
 // See: https://linuxcontainers.org/incus/docs/main/dev-incus/#id2
 func (g *GuestClient) Info() (*incus.InstanceInfo, error) {
-	r, err := handlejson[incus.InstanceInfo](g, InstanceInfoPath, incus.InstanceInfo{})
+	return g.InfoContext(context.Background())
+}
+
+// InfoContext is like Info but takes a context.
+func (g *GuestClient) InfoContext(ctx context.Context) (*incus.InstanceInfo, error) {
+	r, err := cached(g, InstanceInfoPath, identity, func() (incus.InstanceInfo, error) {
+		return handlejson[incus.InstanceInfo](ctx, g, InstanceInfoPath, incus.InstanceInfo{})
+	})
 	return &r, err
 }
 
+// ServerInfo returns information about the guest API itself,
+// such as the API versions it supports.
+//
+// See: https://linuxcontainers.org/incus/docs/main/dev-incus/
+func (g *GuestClient) ServerInfo() (*incus.ServerInfo, error) {
+	return g.ServerInfoContext(context.Background())
+}
+
+// ServerInfoContext is like ServerInfo but takes a context.
+func (g *GuestClient) ServerInfoContext(ctx context.Context) (*incus.ServerInfo, error) {
+	versions, err := handlejson[[]string](ctx, g, RootPath, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &incus.ServerInfo{APIVersions: versions}, nil
+}
+
+// SetState reports the state of the instance to the host.
+//
+// See: https://linuxcontainers.org/incus/docs/main/dev-incus/
+func (g *GuestClient) SetState(ctx context.Context, state string) error {
+	body, err := json.Marshal(map[string]string{"state": state})
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	resp, err := g.do(ctx, http.MethodPatch, bytes.NewReader(body), InstanceInfoPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if g.cache != nil {
+		g.cache.invalidate(InstanceInfoPath)
+	}
+
+	if resp.StatusCode == http.StatusBadRequest {
+		return fmt.Errorf("%w: %s: %w", ErrStateRejected, state, g.newAPIError(resp))
+	} else if resp.StatusCode != http.StatusOK {
+		return g.newAPIError(resp)
+	}
+
+	return nil
+}
+
+// SetReady reports to the host that the instance is ready.
+func (g *GuestClient) SetReady(ctx context.Context) error {
+	return g.SetState(ctx, string(incus.StateReady))
+}
+
 // ListConfig returns a slice of all config keys available to the instance.
+// Keys are returned as the API reports them, as paths like
+// "/1.0/config/user.foo". ListConfigByPrefix returns bare keys.
 //
 // See: https://linuxcontainers.org/incus/docs/main/dev-incus/#config
 func (g *GuestClient) ListConfig() ([]string, error) {
-	s := []string{}
-	return handlejson[[]string](g, ConfigPath, s)
+	return g.ListConfigContext(context.Background())
+}
+
+// ListConfigContext is like ListConfig but takes a context.
+func (g *GuestClient) ListConfigContext(ctx context.Context) ([]string, error) {
+	return cached(g, ConfigPath, slices.Clone, func() ([]string, error) {
+		s := []string{}
+		return handlejson[[]string](ctx, g, ConfigPath, s)
+	})
 }
 
 // Devices returns a map of devices available to the instance.
 //
 // See: https://linuxcontainers.org/incus/docs/main/dev-incus/#devices
 func (g *GuestClient) Devices() (map[string]map[string]string, error) {
-	m := make(map[string]map[string]string)
-	mp, err := handlejson[map[string]map[string]string](g, ListDevicesPath, m)
-	return mp, err
+	return g.DevicesContext(context.Background())
+}
+
+// DevicesContext is like Devices but takes a context.
+func (g *GuestClient) DevicesContext(ctx context.Context) (map[string]map[string]string, error) {
+	return cached(g, ListDevicesPath, cloneDevices, func() (map[string]map[string]string, error) {
+		m := make(map[string]map[string]string)
+		return handlejson[map[string]map[string]string](ctx, g, ListDevicesPath, m)
+	})
 }
 
 // HasConfig checks for the presence of the specified config key.
 //
 // As instances only have access to user.* and cloud-init.*
 // configuration, provided keys will be prefixed with `user.`
-// unless explicitly specified.
+// (or the prefix set by WithDefaultConfigPrefix) unless
+// explicitly specified.
 //
 // See: https://linuxcontainers.org/incus/docs/main/dev-incus/#config-key
 func (g *GuestClient) HasConfig(key string) (bool, error) {
-	formattedKey := key
-	if !strings.HasPrefix(key, "cloud-init.") && !strings.HasPrefix(key, "user.") {
-		formattedKey = fmt.Sprintf("user.%s", key)
+	return g.HasConfigContext(context.Background(), key)
+}
+
+// HasConfigContext is like HasConfig but takes a context.
+func (g *GuestClient) HasConfigContext(ctx context.Context, key string) (bool, error) {
+	return g.hasConfig(ctx, g.configKey(key))
+}
+
+// HasConfigRaw is like HasConfig, but the key is used exactly
+// as provided without being prefixed.
+func (g *GuestClient) HasConfigRaw(key string) (bool, error) {
+	return g.HasConfigRawContext(context.Background(), key)
+}
+
+// HasConfigRawContext is like HasConfigRaw but takes a context.
+func (g *GuestClient) HasConfigRawContext(ctx context.Context, key string) (bool, error) {
+	return g.hasConfig(ctx, key)
+}
+
+// hasConfig checks for the presence of a fully qualified config key.
+func (g *GuestClient) hasConfig(ctx context.Context, key string) (bool, error) {
+	if err := validateConfigKey(key); err != nil {
+		return false, err
 	}
-	endpoint, err := url.JoinPath("http://", ConfigPath, formattedKey)
-	if err != nil {
-		return false, fmt.Errorf("unexpected error: %w", err)
+
+	// A HEAD response doesn't show whether the value is empty,
+	// so fetch it instead.
+	if g.emptyAsNotFound {
+		_, err := g.configValue(ctx, key)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			return false, nil
+		}
+		return err == nil, err
 	}
 
-	resp, err := g.c.Head(endpoint)
+	resp, err := g.do(ctx, http.MethodHead, nil, ConfigPath, key)
 	if err != nil {
-		return false, fmt.Errorf("socket error: %w", err)
+		return false, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
 		return false, nil
 	} else if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("%w: %d", UnexpectedStatusCode, resp.StatusCode)
+		return false, g.newAPIError(resp)
 	}
 
 	return true, nil
 }
 
+// configKey prefixes key with the default config prefix unless
+// it's already in a namespace accessible to the instance.
+func (g *GuestClient) configKey(key string) string {
+	// Leave an empty key empty so that it's rejected as invalid.
+	if key == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(key, "cloud-init.") || strings.HasPrefix(key, "user.") || strings.HasPrefix(key, g.configPrefix) {
+		return key
+	}
+
+	return g.configPrefix + key
+}
+
 // MustConfig calls Config, panicking if there's any
 // error or the key is empty.
 func (g *GuestClient) MustConfig(key string) string {
 	result, err := g.Config(key)
-	if err != nil {
+	if errors.Is(err, ErrConfigKeyNotFound) {
+		panic(fmt.Errorf("config key %s is not set: %w", key, err))
+	} else if err != nil {
 		panic(fmt.Errorf("error loading config key %s: %w", key, err))
 	}
 
@@ -166,126 +734,222 @@ func (g *GuestClient) MustConfig(key string) string {
 }
 
 // Config retrieves the value of the specified instance config key.
+// If the key isn't set, ErrConfigKeyNotFound is returned. A key
+// that is set but empty returns an empty string, unless the client
+// is configured with WithEmptyAsNotFound. Keys that are
+// empty or contain whitespace, control characters or slashes
+// return ErrInvalidConfigKey without making a request.
 //
 // As instances only have access to user.* and cloud-init.*
 // configuration, provided keys will be prefixed with `user.`
-// unless explicitly specified.
+// (or the prefix set by WithDefaultConfigPrefix) unless
+// explicitly specified.
 //
 // See: https://linuxcontainers.org/incus/docs/main/dev-incus/#config-key
 func (g *GuestClient) Config(key string) (string, error) {
-	formattedKey := key
-	if !strings.HasPrefix(key, "cloud-init.") && !strings.HasPrefix(key, "user.") {
-		formattedKey = fmt.Sprintf("user.%s", key)
+	return g.ConfigContext(context.Background(), key)
+}
+
+// ConfigContext is like Config but takes a context.
+func (g *GuestClient) ConfigContext(ctx context.Context, key string) (string, error) {
+	return g.configValue(ctx, g.configKey(key))
+}
+
+// ConfigRaw is like Config, but the key is used exactly as
+// provided without being prefixed. Use it for keys outside
+// the user.* and cloud-init.* namespaces.
+func (g *GuestClient) ConfigRaw(key string) (string, error) {
+	return g.ConfigRawContext(context.Background(), key)
+}
+
+// ConfigRawContext is like ConfigRaw but takes a context.
+func (g *GuestClient) ConfigRawContext(ctx context.Context, key string) (string, error) {
+	return g.configValue(ctx, key)
+}
+
+// configValue retrieves the value of a fully qualified config key.
+func (g *GuestClient) configValue(ctx context.Context, key string) (string, error) {
+	resp, err := g.configResponse(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The value is copied into a string, so the buffer it's
+	// read into can be reused.
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+
+	_, err = buf.ReadFrom(g.body(resp))
+	if err != nil {
+		return "", fmt.Errorf("reader error: %w", err)
+	}
+
+	if buf.Len() == 0 && g.emptyAsNotFound {
+		return "", fmt.Errorf("%w: %s", ErrConfigKeyNotFound, key)
+	}
+
+	return buf.String(), nil
+}
+
+// configEndpoint is the URL config keys are requested from.
+const configEndpoint = "http:/" + ConfigPath + "/"
+
+// configResponse requests a fully qualified config key, returning
+// the response if it was found. The caller must close the body.
+func (g *GuestClient) configResponse(ctx context.Context, key string) (*http.Response, error) {
+	if err := validateConfigKey(key); err != nil {
+		return nil, err
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+
+	// Most keys can be appended to the endpoint as they are, which
+	// saves parsing and joining the URL on every request.
+	if plainPathSegment(key) {
+		req, err = g.newRequestURL(ctx, http.MethodGet, configEndpoint+key, nil)
+	} else {
+		req, err = g.newRequest(ctx, http.MethodGet, nil, ConfigPath, key)
 	}
-	endpoint, err := url.JoinPath("http://", ConfigPath, formattedKey)
 	if err != nil {
-		return "", fmt.Errorf("unexpected error: %w", err)
+		return nil, err
 	}
 
-	resp, err := g.c.Get(endpoint)
+	resp, err := g.send(req)
 	if err != nil {
-		return "", fmt.Errorf("socket error: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return "", nil
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrConfigKeyNotFound, key)
 	} else if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%w: %d", UnexpectedStatusCode, resp.StatusCode)
+		defer resp.Body.Close()
+		return nil, g.newAPIError(resp)
 	}
 
-	result, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("reader error: %w", err)
+	return resp, nil
+}
+
+// validateConfigKey checks that key is a usable config key name:
+// non-empty, without whitespace, control characters or slashes.
+func validateConfigKey(key string) error {
+	if key == "" || key == "." || key == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidConfigKey, key)
 	}
 
-	return string(result), nil
+	for _, r := range key {
+		if r == '/' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return fmt.Errorf("%w: %q", ErrInvalidConfigKey, key)
+		}
+	}
+
+	return nil
 }
 
-// Metadata returns the value of the `cloud-init.user-data` config key.
-//
-// See: https://linuxcontainers.org/incus/docs/main/dev-incus/#meta-data
-func (g *GuestClient) Metadata() (string, error) {
-	var out string
-	endpoint, err := url.JoinPath("http://", MetadataPath)
-	if err != nil {
-		return out, fmt.Errorf("unexpected error: %w", err)
+// plainPathSegment reports whether s can be used as a URL path
+// segment without escaping or cleaning.
+func plainPathSegment(s string) bool {
+	if s == "" || s == "." || s == ".." {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
 	}
 
-	resp, err := g.c.Get(endpoint)
+	return true
+}
+
+// bufferPool holds buffers for reading response bodies.
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// putBuffer returns buf to the pool, unless it's grown large
+// enough that keeping it around would waste memory.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > 64<<10 {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// configBytes retrieves the value of a fully qualified config key.
+func (g *GuestClient) configBytes(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := g.configWithHeader(ctx, key)
+	return value, err
+}
+
+// configWithHeader retrieves the value of a fully qualified config
+// key along with the response headers.
+func (g *GuestClient) configWithHeader(ctx context.Context, key string) ([]byte, http.Header, error) {
+	resp, err := g.configResponse(ctx, key)
 	if err != nil {
-		return out, fmt.Errorf("socket error: %w", err)
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%w: %d", UnexpectedStatusCode, resp.StatusCode)
+	value, err := g.readBody(resp)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	result, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return out, fmt.Errorf("reader error: %w", err)
+	if len(value) == 0 && g.emptyAsNotFound {
+		return nil, nil, fmt.Errorf("%w: %s", ErrConfigKeyNotFound, key)
 	}
 
-	return string(result), nil
+	return value, resp.Header, nil
 }
 
-// ListenForEvents opens a WebSocket connection to the guest events API, blocking
-// the current goroutine. It takes a callback function and an optional list of events
-// to subscribe to. If no events are provided, it will subscribe to all of them.
+// MetaData returns the cloud-init meta-data for the instance, as
+// served by the /1.0/meta-data endpoint.
 //
-// See the definition for incus.EventType for valid values.
-func (g *GuestClient) ListenForEvents(ctx context.Context, callback func(*incus.Event), events ...incus.EventType) error {
-	endpoint, err := url.JoinPath("ws://", EventsPath)
+// See: https://linuxcontainers.org/incus/docs/main/dev-incus/#meta-data
+func (g *GuestClient) MetaData() (string, error) {
+	return g.MetaDataContext(context.Background())
+}
+
+// MetaDataContext is like MetaData but takes a context.
+func (g *GuestClient) MetaDataContext(ctx context.Context) (string, error) {
+	resp, err := g.get(ctx, MetadataPath)
 	if err != nil {
-		return fmt.Errorf("unexpected error: %w", err)
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	// Only subscribe to specific events
-	if len(events) > 0 {
-		parsed, err := url.Parse(endpoint)
-		if err != nil {
-			return fmt.Errorf("unexpected error: %w", err)
-		}
-
-		strEvents := []string{}
-		for _, ev := range events {
-			if ev.Valid() {
-				strEvents = append(strEvents, string(ev))
-			}
-		}
-
-		val := url.Values{}
-		val.Add("type", strings.Join(strEvents, ","))
-		parsed.RawQuery = val.Encode()
-		endpoint = parsed.String()
+	if resp.StatusCode != http.StatusOK {
+		return "", g.newAPIError(resp)
 	}
 
-	conn, _, err := websocket.Dial(ctx, endpoint, &websocket.DialOptions{
-		HTTPClient: g.c,
-	})
+	result, err := g.readBody(resp)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer conn.CloseNow()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			_, message, err := conn.Reader(ctx)
-			if err != nil {
-				return fmt.Errorf("error in reader: %w", err)
-			}
+	return string(result), nil
+}
 
-			ev := &incus.Event{}
-			err = json.NewDecoder(message).Decode(ev)
-			if err != nil {
-				return fmt.Errorf("error in json unmarshaller: %w", err)
-			}
+// Metadata returns the cloud-init meta-data for the instance.
+//
+// Deprecated: Use MetaData instead.
+func (g *GuestClient) Metadata() (string, error) {
+	return g.MetaData()
+}
 
-			go callback(ev)
-		}
-	}
+// MetadataContext is like Metadata but takes a context.
+//
+// Deprecated: Use MetaDataContext instead.
+func (g *GuestClient) MetadataContext(ctx context.Context) (string, error) {
+	return g.MetaDataContext(ctx)
 }
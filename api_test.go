@@ -0,0 +1,356 @@
+package guest_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+	"github.com/shellhazard/incus-guestapi/incus"
+	"nhooyr.io/websocket"
+)
+
+// newServer starts a guesttest server, shutting it down
+// when the test finishes.
+func newServer(t *testing.T, opts guesttest.Options) *guesttest.Server {
+	t.Helper()
+
+	srv := guesttest.NewServer(opts)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// serveSocket serves handler on a unix socket in a temporary
+// directory, returning the socket's path.
+func serveSocket(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return path
+}
+
+// slowHandler responds after delay, or gives up once the
+// request is cancelled.
+func slowHandler(delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"api_version":"1.0","instance_type":"container","state":"Running"}`))
+	})
+}
+
+// serveEvents serves an events API on a unix socket, calling send
+// with each connection it accepts. Connections are kept open until
+// the client closes them.
+func serveEvents(t *testing.T, send func(ctx context.Context, conn *websocket.Conn)) string {
+	t.Helper()
+
+	return serveSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+
+		ctx := conn.CloseRead(context.Background())
+		send(ctx, conn)
+		<-ctx.Done()
+	}))
+}
+
+// configEvent returns a config event setting key to value,
+// as sent by the events API.
+func configEvent(key, value string) []byte {
+	return []byte(fmt.Sprintf(`{"timestamp":"2024-01-02T03:04:05Z","type":"config","metadata":{"key":%q,"old_value":"","value":%q}}`, key, value))
+}
+
+func TestTimeout(t *testing.T) {
+	path := serveSocket(t, slowHandler(time.Second))
+
+	g := guest.NewClient(guest.WithSocketPath(path), guest.WithTimeout(50*time.Millisecond))
+	if _, err := g.Info(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Info() with client timeout: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTimeoutContext(t *testing.T) {
+	path := serveSocket(t, slowHandler(time.Second))
+
+	g := guest.NewClient(guest.WithSocketPath(path))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := g.InfoContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("InfoContext() with deadline: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTimeoutBoundsEventsDial(t *testing.T) {
+	path := serveSocket(t, slowHandler(time.Second))
+
+	g := guest.NewClient(guest.WithSocketPath(path), guest.WithTimeout(50*time.Millisecond))
+
+	err := g.ListenForEvents(context.Background(), func(ev *incus.Event) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ListenForEvents() with client timeout: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTimeoutDoesNotBoundEventsRead(t *testing.T) {
+	path := serveEvents(t, func(ctx context.Context, conn *websocket.Conn) {
+		// Outlive the timeout before anything is sent.
+		time.Sleep(200 * time.Millisecond)
+		conn.Write(ctx, websocket.MessageText, configEvent("user.foo", "bar"))
+	})
+
+	g := guest.NewClient(guest.WithSocketPath(path), guest.WithTimeout(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evc := make(chan *incus.Event, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- g.ListenForEvents(ctx, func(ev *incus.Event) {
+			evc <- ev
+		})
+	}()
+
+	select {
+	case ev := <-evc:
+		if ev.Config.Key != "user.foo" {
+			t.Fatalf("got event for %q, want user.foo", ev.Config.Key)
+		}
+	case err := <-errc:
+		t.Fatalf("events connection ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func BenchmarkDevices(b *testing.B) {
+	devices := make(map[string]map[string]string)
+	for i := 0; i < 32; i++ {
+		devices[fmt.Sprintf("eth%d", i)] = map[string]string{
+			"type":    "nic",
+			"network": "incusbr0",
+			"name":    fmt.Sprintf("eth%d", i),
+			"hwaddr":  fmt.Sprintf("00:16:3e:00:00:%02x", i),
+		}
+	}
+
+	srv := guesttest.NewServer(guesttest.Options{Devices: devices})
+	defer srv.Close()
+
+	g := srv.Client()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Devices(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config:  map[string]string{"user.foo": "0"},
+		Devices: map[string]map[string]string{"eth0": {"type": "nic"}},
+	})
+
+	onConnect, connected := notifyConnect()
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		// A short TTL so entries expire and are refilled while
+		// other goroutines are reading them.
+		guest.WithCache(time.Millisecond),
+		guest.WithCircuitBreaker(5, time.Second),
+		onConnect,
+	)
+
+	// Config events invalidate cached entries from the listener's
+	// goroutine.
+	listen(t, g, func(ev *incus.Event) {})
+	await(t, "connection", connected)
+
+	const goroutines = 16
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*iterations)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				if _, err := g.Config("foo"); err != nil {
+					errs <- fmt.Errorf("Config: %w", err)
+				}
+				if _, err := g.Info(); err != nil {
+					errs <- fmt.Errorf("Info: %w", err)
+				}
+				if _, err := g.Devices(); err != nil {
+					errs <- fmt.Errorf("Devices: %w", err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < iterations; i++ {
+		srv.SetConfig("user.foo", strconv.Itoa(i))
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if state := g.BreakerState(); state != guest.CircuitClosed {
+		t.Fatalf("circuit breaker is %v, want closed", state)
+	}
+}
+
+// roundTripperFunc adapts a function to an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGzipResponses(t *testing.T) {
+	devices := `{"eth0":{"type":"nic","network":"incusbr0"}}`
+	metadata := "instance-id: c1\nlocal-hostname: c1\n"
+
+	path := serveSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body, contentType string
+		switch r.URL.Path {
+		case "/1.0/devices":
+			body, contentType = devices, "application/json"
+		case "/1.0/meta-data":
+			body, contentType = metadata, "application/octet-stream"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", "gzip")
+
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(body))
+		zw.Close()
+	}))
+
+	// Setting Accept-Encoding stops the transport from
+	// decompressing the response itself.
+	acceptGzip := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("Accept-Encoding", "gzip")
+			return next.RoundTrip(req)
+		})
+	}
+
+	clients := map[string]*guest.GuestClient{
+		"middleware": guest.NewClient(
+			guest.WithSocketPath(path),
+			guest.WithMiddleware(acceptGzip),
+		),
+		"compression disabled": guest.NewClient(
+			guest.WithSocketPath(path),
+			guest.WithHTTPClient(&http.Client{Transport: &http.Transport{DisableCompression: true}}),
+			guest.WithMiddleware(acceptGzip),
+		),
+	}
+
+	for name, g := range clients {
+		t.Run(name, func(t *testing.T) {
+			got, err := g.Devices()
+			if err != nil {
+				t.Fatalf("Devices: %v", err)
+			}
+
+			want := map[string]map[string]string{"eth0": {"type": "nic", "network": "incusbr0"}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Devices: got %v, want %v", got, want)
+			}
+
+			md, err := g.MetaData()
+			if err != nil {
+				t.Fatalf("MetaData: %v", err)
+			}
+
+			if md != metadata {
+				t.Fatalf("MetaData: got %q, want %q", md, metadata)
+			}
+		})
+	}
+}
+
+func TestSetStateRejected(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	g := srv.Client()
+
+	err := g.SetState(context.Background(), "Sleeping")
+	if err == nil {
+		t.Fatal("SetState with an invalid state succeeded")
+	}
+
+	if !errors.Is(err, guest.UnexpectedStatusCode) {
+		t.Fatalf("got error %v, want it to match UnexpectedStatusCode", err)
+	}
+
+	if !errors.Is(err, guest.ErrStateRejected) {
+		t.Fatalf("got error %v, want it to match ErrStateRejected", err)
+	}
+
+	var apiErr *guest.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got error %v, want an *APIError", err)
+	}
+
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", apiErr.StatusCode, http.StatusBadRequest)
+	}
+
+	if want := `Invalid state "Sleeping"`; apiErr.Message != want {
+		t.Fatalf("got message %q, want %q", apiErr.Message, want)
+	}
+
+	// A valid state is still accepted afterwards.
+	if err := g.SetState(context.Background(), "Ready"); err != nil {
+		t.Fatalf("SetState(Ready): %v", err)
+	}
+}
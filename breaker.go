@@ -0,0 +1,122 @@
+package guest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the client's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through as normal.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen fails requests with ErrCircuitOpen without
+	// contacting the socket.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a single probe request through to
+	// decide whether to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// breaker stops requests to the socket after repeated failures.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a request may be made, moving an open
+// circuit to half-open once the cooldown has elapsed.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = CircuitHalfOpen
+	}
+
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !failed {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon records that a request ended without an outcome,
+// letting another probe through if it was one.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// current returns the state of the breaker.
+func (b *breaker) current() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+
+	return b.state
+}
+
+// BreakerState returns the state of the client's circuit breaker.
+// It's always CircuitClosed unless WithCircuitBreaker is used.
+func (g *GuestClient) BreakerState() CircuitState {
+	if g.breaker == nil {
+		return CircuitClosed
+	}
+
+	return g.breaker.current()
+}
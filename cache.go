@@ -0,0 +1,124 @@
+package guest
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// cache holds recent responses, keyed by path.
+type cache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	// gen is incremented on each invalidation, so that a fetch
+	// started beforehand doesn't store a stale value.
+	gen uint64
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// invalidate removes the entries for the given paths, or
+// every entry if none are given.
+func (c *cache) invalidate(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	if len(paths) == 0 {
+		clear(c.entries)
+		return
+	}
+
+	for _, path := range paths {
+		delete(c.entries, path)
+	}
+}
+
+// cached returns a copy of the cached value for path if the client
+// has a cache and the entry hasn't expired, otherwise it calls fetch
+// and caches the result.
+func cached[T any](g *GuestClient, path string, clone func(T) T, fetch func() (T, error)) (T, error) {
+	c := g.cache
+	if c == nil {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	gen := c.gen
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return clone(entry.value.(T)), nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	if c.gen == gen {
+		c.entries[path] = cacheEntry{
+			value:   clone(value),
+			expires: time.Now().Add(c.ttl),
+		}
+	}
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+// invalidateFor removes cache entries that ev may have made stale.
+func (g *GuestClient) invalidateFor(ev *incus.Event) {
+	if g.cache == nil {
+		return
+	}
+
+	switch ev.Type {
+	case incus.EventTypeConfig:
+		g.cache.invalidate(ConfigPath)
+	case incus.EventTypeDevice:
+		g.cache.invalidate(ListDevicesPath)
+	}
+}
+
+// InvalidateCache discards everything cached by the client, so the
+// next call of each cached method contacts the guest API. It does
+// nothing unless WithCache is used.
+func (g *GuestClient) InvalidateCache() {
+	if g.cache != nil {
+		g.cache.invalidate()
+	}
+}
+
+func cloneDevices(devices map[string]map[string]string) map[string]map[string]string {
+	out := make(map[string]map[string]string, len(devices))
+	for name, props := range devices {
+		out[name] = maps.Clone(props)
+	}
+
+	return out
+}
+
+// identity is the clone function for values that are
+// copied on assignment.
+func identity[T any](v T) T {
+	return v
+}
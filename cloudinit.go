@@ -0,0 +1,112 @@
+package guest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+	"gopkg.in/yaml.v3"
+)
+
+// MetaDataParsed is like MetaData, but parses the returned YAML.
+func (g *GuestClient) MetaDataParsed() (*incus.MetaData, error) {
+	return g.MetaDataParsedContext(context.Background())
+}
+
+// MetaDataParsedContext is like MetaDataParsed but takes a context.
+func (g *GuestClient) MetaDataParsedContext(ctx context.Context) (*incus.MetaData, error) {
+	raw, err := g.MetaDataContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &incus.MetaData{}
+	err = yaml.Unmarshal([]byte(raw), md)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+
+	return md, nil
+}
+
+// PublicKeys returns the SSH public keys in the meta-data, by name.
+// See incus.PublicKeys for how each representation is handled.
+func (g *GuestClient) PublicKeys() (map[string]string, error) {
+	return g.PublicKeysContext(context.Background())
+}
+
+// PublicKeysContext is like PublicKeys but takes a context.
+func (g *GuestClient) PublicKeysContext(ctx context.Context) (map[string]string, error) {
+	md, err := g.MetaDataParsedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if md.PublicKeys == nil {
+		return map[string]string{}, nil
+	}
+
+	return md.PublicKeys, nil
+}
+
+// SSHAuthorizedKeys returns the SSH public keys in the meta-data
+// as a list, one key per entry.
+func (g *GuestClient) SSHAuthorizedKeys() ([]string, error) {
+	return g.SSHAuthorizedKeysContext(context.Background())
+}
+
+// SSHAuthorizedKeysContext is like SSHAuthorizedKeys but takes a context.
+func (g *GuestClient) SSHAuthorizedKeysContext(ctx context.Context) ([]string, error) {
+	md, err := g.MetaDataParsedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return md.PublicKeys.AuthorizedKeys(), nil
+}
+
+// UserData returns the value of the `cloud-init.user-data` config key.
+func (g *GuestClient) UserData() (string, error) {
+	return g.UserDataContext(context.Background())
+}
+
+// UserDataContext is like UserData but takes a context.
+func (g *GuestClient) UserDataContext(ctx context.Context) (string, error) {
+	return g.ConfigContext(ctx, "cloud-init.user-data")
+}
+
+// UserDataParts is like UserData, but splits the user-data into its
+// parts if it's a MIME multipart document. See incus.ParseUserData.
+func (g *GuestClient) UserDataParts() ([]incus.UserDataPart, error) {
+	return g.UserDataPartsContext(context.Background())
+}
+
+// UserDataPartsContext is like UserDataParts but takes a context.
+func (g *GuestClient) UserDataPartsContext(ctx context.Context) ([]incus.UserDataPart, error) {
+	data, err := g.UserDataContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return incus.ParseUserData(data)
+}
+
+// VendorData returns the value of the `cloud-init.vendor-data` config key.
+func (g *GuestClient) VendorData() (string, error) {
+	return g.VendorDataContext(context.Background())
+}
+
+// VendorDataContext is like VendorData but takes a context.
+func (g *GuestClient) VendorDataContext(ctx context.Context) (string, error) {
+	return g.ConfigContext(ctx, "cloud-init.vendor-data")
+}
+
+// NetworkConfig returns the value of the `cloud-init.network-config` config key.
+func (g *GuestClient) NetworkConfig() (string, error) {
+	return g.NetworkConfigContext(context.Background())
+}
+
+// NetworkConfigContext is like NetworkConfig but takes a context.
+func (g *GuestClient) NetworkConfigContext(ctx context.Context) (string, error) {
+	return g.ConfigContext(ctx, "cloud-init.network-config")
+}
@@ -0,0 +1,592 @@
+package guest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// configWorkers bounds the number of concurrent requests
+// made when fetching many config keys at once.
+const configWorkers = 4
+
+// ConfigWithDefault calls Config, returning def if the key isn't set.
+// Keys are prefixed in the same way as Config.
+func (g *GuestClient) ConfigWithDefault(key, def string) (string, error) {
+	return g.ConfigWithDefaultContext(context.Background(), key, def)
+}
+
+// ConfigWithDefaultContext is like ConfigWithDefault but takes a context.
+func (g *GuestClient) ConfigWithDefaultContext(ctx context.Context, key, def string) (string, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if errors.Is(err, ErrConfigKeyNotFound) {
+		return def, nil
+	}
+
+	return value, err
+}
+
+// TryConfig is like Config, but reports whether the key is set
+// rather than returning ErrConfigKeyNotFound. An error is only
+// returned if the key couldn't be retrieved.
+func (g *GuestClient) TryConfig(key string) (string, bool, error) {
+	return g.TryConfigContext(context.Background(), key)
+}
+
+// TryConfigContext is like TryConfig but takes a context.
+func (g *GuestClient) TryConfigContext(ctx context.Context, key string) (string, bool, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if errors.Is(err, ErrConfigKeyNotFound) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+// ConfigInt retrieves the specified config key and parses it as an int.
+// A key that is set but empty is treated as invalid rather than zero.
+func (g *GuestClient) ConfigInt(key string) (int, error) {
+	return g.ConfigIntContext(context.Background(), key)
+}
+
+// ConfigIntContext is like ConfigInt but takes a context.
+func (g *GuestClient) ConfigIntContext(ctx context.Context, key string) (int, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("config key %s is not a valid int: %w", g.configKey(key), err)
+	}
+
+	return i, nil
+}
+
+// ConfigInt64 retrieves the specified config key and parses it as an int64.
+// A key that is set but empty is treated as invalid rather than zero.
+func (g *GuestClient) ConfigInt64(key string) (int64, error) {
+	return g.ConfigInt64Context(context.Background(), key)
+}
+
+// ConfigInt64Context is like ConfigInt64 but takes a context.
+func (g *GuestClient) ConfigInt64Context(ctx context.Context, key string) (int64, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("config key %s is not a valid int64: %w", g.configKey(key), err)
+	}
+
+	return i, nil
+}
+
+// ConfigBool retrieves the specified config key and parses it as a bool.
+// Accepted values are those understood by strconv.ParseBool.
+func (g *GuestClient) ConfigBool(key string) (bool, error) {
+	return g.ConfigBoolContext(context.Background(), key)
+}
+
+// ConfigBoolContext is like ConfigBool but takes a context.
+func (g *GuestClient) ConfigBoolContext(ctx context.Context, key string) (bool, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("config key %s is not a valid bool: %w", g.configKey(key), err)
+	}
+
+	return b, nil
+}
+
+// ConfigBoolWithDefault calls ConfigBool, returning def if the key
+// isn't set. Values that can't be parsed still return an error.
+func (g *GuestClient) ConfigBoolWithDefault(key string, def bool) (bool, error) {
+	return g.ConfigBoolWithDefaultContext(context.Background(), key, def)
+}
+
+// ConfigBoolWithDefaultContext is like ConfigBoolWithDefault but
+// takes a context.
+func (g *GuestClient) ConfigBoolWithDefaultContext(ctx context.Context, key string, def bool) (bool, error) {
+	b, err := g.ConfigBoolContext(ctx, key)
+	if errors.Is(err, ErrConfigKeyNotFound) {
+		return def, nil
+	}
+
+	return b, err
+}
+
+// ConfigTyped is like Config, but returns the value along with the
+// kind of data it appears to hold, for displaying config without
+// knowing the type of each key in advance.
+func (g *GuestClient) ConfigTyped(key string) (incus.ConfigValue, error) {
+	return g.ConfigTypedContext(context.Background(), key)
+}
+
+// ConfigTypedContext is like ConfigTyped but takes a context.
+func (g *GuestClient) ConfigTypedContext(ctx context.Context, key string) (incus.ConfigValue, error) {
+	value, err := g.ConfigContext(ctx, key)
+	if err != nil {
+		return incus.ConfigValue{}, err
+	}
+
+	return incus.ParseConfigValue(value), nil
+}
+
+// ConfigBytes is like Config, but returns the value exactly as
+// received, for values that may not be valid UTF-8.
+func (g *GuestClient) ConfigBytes(key string) ([]byte, error) {
+	return g.ConfigBytesContext(context.Background(), key)
+}
+
+// ConfigBytesContext is like ConfigBytes but takes a context.
+func (g *GuestClient) ConfigBytesContext(ctx context.Context, key string) ([]byte, error) {
+	return g.configBytes(ctx, g.configKey(key))
+}
+
+// ConfigWithMeta is like Config, but also returns the response
+// headers, such as Content-Type and ETag.
+func (g *GuestClient) ConfigWithMeta(key string) (string, http.Header, error) {
+	return g.ConfigWithMetaContext(context.Background(), key)
+}
+
+// ConfigWithMetaContext is like ConfigWithMeta but takes a context.
+func (g *GuestClient) ConfigWithMetaContext(ctx context.Context, key string) (string, http.Header, error) {
+	value, header, err := g.configWithHeader(ctx, g.configKey(key))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(value), header, nil
+}
+
+// ConfigIfChanged retrieves the specified config key unless it still
+// matches etag, as returned by a previous call. If it does, changed is
+// false and value is empty. Pass an empty etag to always retrieve the
+// value. Keys are prefixed in the same way as Config.
+//
+// If the guest API supports ETags, an unchanged value isn't downloaded
+// again. Otherwise, an ETag is derived from the value itself, so changes
+// are still detected, but the value is always downloaded.
+func (g *GuestClient) ConfigIfChanged(key, etag string) (value string, newEtag string, changed bool, err error) {
+	return g.ConfigIfChangedContext(context.Background(), key, etag)
+}
+
+// ConfigIfChangedContext is like ConfigIfChanged but takes a context.
+func (g *GuestClient) ConfigIfChangedContext(ctx context.Context, key, etag string) (value string, newEtag string, changed bool, err error) {
+	formattedKey := g.configKey(key)
+	if err := validateConfigKey(formattedKey); err != nil {
+		return "", "", false, err
+	}
+
+	req, err := g.newRequest(ctx, http.MethodGet, nil, ConfigPath, formattedKey)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := g.send(req)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return "", etag, false, nil
+	case http.StatusNotFound:
+		return "", "", false, fmt.Errorf("%w: %s", ErrConfigKeyNotFound, formattedKey)
+	default:
+		return "", "", false, g.newAPIError(resp)
+	}
+
+	body, err := g.readBody(resp)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	newEtag = responseETag(resp, body)
+
+	if newEtag == etag {
+		return "", etag, false, nil
+	}
+
+	return string(body), newEtag, true, nil
+}
+
+// responseETag returns the ETag of resp, or if it has none, a weak
+// ETag derived from its body.
+func responseETag(resp *http.Response, body []byte) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// ConfigFull retrieves the specified config key as an entry holding
+// its value exactly as received, its ETag and its content type. If
+// the key isn't set, Found is false rather than an error returned.
+// Keys are prefixed in the same way as Config, and the ETag is found
+// in the same way as ConfigIfChanged.
+func (g *GuestClient) ConfigFull(ctx context.Context, key string) (incus.ConfigEntry, error) {
+	resp, err := g.configResponse(ctx, g.configKey(key))
+	if errors.Is(err, ErrConfigKeyNotFound) {
+		return incus.ConfigEntry{}, nil
+	} else if err != nil {
+		return incus.ConfigEntry{}, err
+	}
+	defer resp.Body.Close()
+
+	value, err := g.readBody(resp)
+	if err != nil {
+		return incus.ConfigEntry{}, err
+	}
+
+	if len(value) == 0 && g.emptyAsNotFound {
+		return incus.ConfigEntry{}, nil
+	}
+
+	return incus.ConfigEntry{
+		Value:       value,
+		ETag:        responseETag(resp, value),
+		ContentType: resp.Header.Get("Content-Type"),
+		Found:       true,
+	}, nil
+}
+
+// ConfigReader is like ConfigContext, but returns the body of the
+// response for the value to be read as a stream, for values too large
+// to hold in memory. The size limit set by WithMaxResponseBytes doesn't
+// apply. If the key isn't set, ErrConfigKeyNotFound is returned. The
+// caller must close the reader.
+func (g *GuestClient) ConfigReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := g.configResponse(ctx, g.configKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// ConfigBase64 retrieves the specified config key and decodes it
+// as standard base64. Surrounding whitespace is ignored.
+func (g *GuestClient) ConfigBase64(key string) ([]byte, error) {
+	return g.ConfigBase64Context(context.Background(), key)
+}
+
+// ConfigBase64Context is like ConfigBase64 but takes a context.
+func (g *GuestClient) ConfigBase64Context(ctx context.Context, key string) ([]byte, error) {
+	value, err := g.ConfigBytesContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	value = bytes.TrimSpace(value)
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(value)))
+
+	n, err := base64.StdEncoding.Decode(out, value)
+	if err != nil {
+		return nil, fmt.Errorf("config key %s is not valid base64: %w", g.configKey(key), err)
+	}
+
+	return out[:n], nil
+}
+
+// ConfigJSON retrieves the specified config key and unmarshals it
+// into a value of type T.
+func ConfigJSON[T any](g *GuestClient, key string) (T, error) {
+	var target T
+
+	value, err := g.Config(key)
+	if err != nil {
+		return target, err
+	}
+
+	err = json.Unmarshal([]byte(value), &target)
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("config key %s is not valid json: %w", g.configKey(key), err)
+	}
+
+	return target, nil
+}
+
+// configKeys lists the fully qualified config keys available to
+// the instance. The API returns each key as a path under ConfigPath,
+// so any such prefix is removed.
+func (g *GuestClient) configKeys(ctx context.Context) ([]string, error) {
+	keys, err := g.ListConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, "/1.0/config/")
+	}
+
+	return keys, nil
+}
+
+// ListConfigByPrefix returns the config keys that begin with
+// prefix, such as "user.". Unlike ListConfig, which returns each
+// key as a path like "/1.0/config/user.foo", keys are returned
+// bare, like "user.foo".
+func (g *GuestClient) ListConfigByPrefix(prefix string) ([]string, error) {
+	return g.ListConfigByPrefixContext(context.Background(), prefix)
+}
+
+// ListConfigByPrefixContext is like ListConfigByPrefix but takes a context.
+func (g *GuestClient) ListConfigByPrefixContext(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := g.configKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			filtered = append(filtered, key)
+		}
+	}
+
+	return filtered, nil
+}
+
+// ListUserConfig returns all user.* config keys, without the
+// path prefix ListConfig includes.
+func (g *GuestClient) ListUserConfig() ([]string, error) {
+	return g.ListUserConfigContext(context.Background())
+}
+
+// ListUserConfigContext is like ListUserConfig but takes a context.
+func (g *GuestClient) ListUserConfigContext(ctx context.Context) ([]string, error) {
+	return g.ListConfigByPrefixContext(ctx, "user.")
+}
+
+// ListUserConfigNames is like ListUserConfig, but returns the keys
+// without the "user." prefix. With the default config prefix, the
+// names can be passed to Config as they are.
+func (g *GuestClient) ListUserConfigNames() ([]string, error) {
+	return g.ListUserConfigNamesContext(context.Background())
+}
+
+// ListUserConfigNamesContext is like ListUserConfigNames but takes a context.
+func (g *GuestClient) ListUserConfigNamesContext(ctx context.Context) ([]string, error) {
+	keys, err := g.ListUserConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, "user.")
+	}
+
+	return keys, nil
+}
+
+// ListCloudInitConfig returns all cloud-init.* config keys,
+// without the path prefix ListConfig includes.
+func (g *GuestClient) ListCloudInitConfig() ([]string, error) {
+	return g.ListCloudInitConfigContext(context.Background())
+}
+
+// ListCloudInitConfigContext is like ListCloudInitConfig but takes a context.
+func (g *GuestClient) ListCloudInitConfigContext(ctx context.Context) ([]string, error) {
+	return g.ListConfigByPrefixContext(ctx, "cloud-init.")
+}
+
+// AllConfig returns every config key available to the instance
+// along with its value.
+func (g *GuestClient) AllConfig() (map[string]string, error) {
+	return g.AllConfigContext(context.Background())
+}
+
+// AllConfigContext is like AllConfig but takes a context. Values
+// are fetched concurrently, and if any fetch fails the rest are
+// cancelled and the error is returned.
+func (g *GuestClient) AllConfigContext(ctx context.Context) (map[string]string, error) {
+	keys, err := g.configKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	out := make(map[string]string, len(keys))
+
+	err = parallel(ctx, keys, func(ctx context.Context, key string) error {
+		value, err := g.configValue(ctx, key)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			// Removed since we listed the keys.
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		out[key] = value
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// AllConfigPartial is like AllConfig, but a value that can't be
+// fetched doesn't stop the others. It returns every value fetched
+// along with the error for each key that failed. An error is only
+// returned if the keys can't be listed, or if ctx is done before
+// every key has been tried.
+func (g *GuestClient) AllConfigPartial() (map[string]string, map[string]error, error) {
+	return g.AllConfigPartialContext(context.Background())
+}
+
+// AllConfigPartialContext is like AllConfigPartial but takes a context.
+func (g *GuestClient) AllConfigPartialContext(ctx context.Context) (map[string]string, map[string]error, error) {
+	keys, err := g.configKeys(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mu sync.Mutex
+	out := make(map[string]string, len(keys))
+	errs := make(map[string]error)
+
+	// Errors are collected rather than returned, so
+	// the remaining fetches are never cancelled.
+	err = parallel(ctx, keys, func(ctx context.Context, key string) error {
+		value, err := g.configValue(ctx, key)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			// Removed since we listed the keys.
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			errs[key] = err
+		} else {
+			out[key] = value
+		}
+
+		return nil
+	})
+
+	return out, errs, err
+}
+
+// HasConfigAll is like HasConfig, but checks for each of keys
+// concurrently, returning whether each is present. Keys are
+// prefixed in the same way as HasConfig, but the returned map
+// uses them as provided.
+func (g *GuestClient) HasConfigAll(keys ...string) (map[string]bool, error) {
+	return g.HasConfigAllContext(context.Background(), keys...)
+}
+
+// HasConfigAllContext is like HasConfigAll but takes a context.
+func (g *GuestClient) HasConfigAllContext(ctx context.Context, keys ...string) (map[string]bool, error) {
+	var mu sync.Mutex
+	out := make(map[string]bool, len(keys))
+
+	err := parallel(ctx, keys, func(ctx context.Context, key string) error {
+		ok, err := g.hasConfig(ctx, g.configKey(key))
+		if err != nil {
+			return fmt.Errorf("checking config key %s: %w", g.configKey(key), err)
+		}
+
+		mu.Lock()
+		out[key] = ok
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// parallel calls fn for each of keys using a bounded number of
+// workers. If any call fails, the context passed to the others
+// is cancelled and the first error is returned.
+func parallel(ctx context.Context, keys []string, fn func(ctx context.Context, key string) error) error {
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	jobs := make(chan string)
+
+	for i := 0; i < min(configWorkers, len(keys)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				err := fn(workerCtx, key)
+				if err == nil {
+					continue
+				}
+
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, key := range keys {
+		select {
+		case jobs <- key:
+		case <-workerCtx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
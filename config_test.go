@@ -0,0 +1,375 @@
+package guest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+)
+
+// serveConfig serves the config endpoint of the guest API from
+// config, returning the socket's path.
+func serveConfig(t *testing.T, config map[string]string) string {
+	t.Helper()
+
+	return serveSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.URL.Path, "/1.0/config/")
+		if !ok || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+
+		value, ok := config[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(value))
+	}))
+}
+
+func TestConfigJSON(t *testing.T) {
+	path := serveConfig(t, map[string]string{
+		"user.server": `{"host":"example.com","port":8080,"tags":["a","b"]}`,
+		"user.limits": `{"cpu":2,"memory":512}`,
+		"user.broken": `{"host":`,
+	})
+	g := guest.NewClient(guest.WithSocketPath(path))
+
+	type server struct {
+		Host string   `json:"host"`
+		Port int      `json:"port"`
+		Tags []string `json:"tags"`
+	}
+
+	t.Run("struct", func(t *testing.T) {
+		got, err := guest.ConfigJSON[server](g, "server")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := server{Host: "example.com", Port: 8080, Tags: []string{"a", "b"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		got, err := guest.ConfigJSON[map[string]int](g, "limits")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]int{"cpu": 2, "memory": 512}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		got, err := guest.ConfigJSON[server](g, "missing")
+		if !errors.Is(err, guest.ErrConfigKeyNotFound) {
+			t.Fatalf("got error %v, want ErrConfigKeyNotFound", err)
+		}
+
+		// The error is passed through, not reported as bad JSON.
+		if strings.Contains(err.Error(), "json") {
+			t.Fatalf("got error %q, want it unwrapped", err)
+		}
+
+		if !reflect.DeepEqual(got, server{}) {
+			t.Fatalf("got %+v, want the zero value", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		got, err := guest.ConfigJSON[server](g, "broken")
+		if err == nil || errors.Is(err, guest.ErrConfigKeyNotFound) {
+			t.Fatalf("got error %v, want a JSON error", err)
+		}
+
+		if !reflect.DeepEqual(got, server{}) {
+			t.Fatalf("got %+v, want the zero value", got)
+		}
+	})
+}
+
+func TestConfigIntContext(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{
+			"user.count": "42",
+			"user.big":   "9000000000",
+			"user.bad":   "nope",
+		},
+	})
+	g := srv.Client()
+	ctx := context.Background()
+
+	if i, err := g.ConfigIntContext(ctx, "count"); err != nil || i != 42 {
+		t.Fatalf("ConfigIntContext: got %d, %v", i, err)
+	}
+
+	if i, err := g.ConfigInt64Context(ctx, "big"); err != nil || i != 9000000000 {
+		t.Fatalf("ConfigInt64Context: got %d, %v", i, err)
+	}
+
+	if _, err := g.ConfigIntContext(ctx, "bad"); err == nil || errors.Is(err, guest.ErrConfigKeyNotFound) {
+		t.Fatalf("ConfigIntContext on invalid value: got %v, want a parse error", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := g.ConfigIntContext(cancelled, "count"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ConfigIntContext with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestConfigBoolContext(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{
+			"user.enabled": "true",
+			"user.bad":     "nope",
+		},
+	})
+	g := srv.Client()
+	ctx := context.Background()
+
+	if b, err := g.ConfigBoolContext(ctx, "enabled"); err != nil || !b {
+		t.Fatalf("ConfigBoolContext: got %v, %v", b, err)
+	}
+
+	if b, err := g.ConfigBoolWithDefaultContext(ctx, "missing", true); err != nil || !b {
+		t.Fatalf("ConfigBoolWithDefaultContext: got %v, %v", b, err)
+	}
+
+	if _, err := g.ConfigBoolWithDefaultContext(ctx, "bad", true); err == nil {
+		t.Fatal("ConfigBoolWithDefaultContext on invalid value: got nil, want a parse error")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := g.ConfigBoolContext(cancelled, "enabled"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ConfigBoolContext with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestConfigWithDefaultContext(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{"user.foo": "bar"},
+	})
+	g := srv.Client()
+	ctx := context.Background()
+
+	if v, err := g.ConfigWithDefaultContext(ctx, "foo", "def"); err != nil || v != "bar" {
+		t.Fatalf("ConfigWithDefaultContext: got %q, %v, want bar", v, err)
+	}
+
+	if v, err := g.ConfigWithDefaultContext(ctx, "missing", "def"); err != nil || v != "def" {
+		t.Fatalf("ConfigWithDefaultContext: got %q, %v, want def", v, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := g.ConfigWithDefaultContext(cancelled, "missing", "def"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ConfigWithDefaultContext with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestListConfigByPrefix(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{
+			"user.foo":          "1",
+			"user.bar":          "2",
+			"cloud-init.vendor": "#cloud-config",
+		},
+	})
+	g := srv.Client()
+	ctx := context.Background()
+
+	all, err := g.ListConfigContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/1.0/config/cloud-init.vendor", "/1.0/config/user.bar", "/1.0/config/user.foo"}
+	if !reflect.DeepEqual(all, want) {
+		t.Fatalf("ListConfigContext: got %v, want %v", all, want)
+	}
+
+	tests := []struct {
+		name string
+		list func(context.Context) ([]string, error)
+		want []string
+	}{
+		{
+			name: "user",
+			list: g.ListUserConfigContext,
+			want: []string{"user.bar", "user.foo"},
+		},
+		{
+			name: "user names",
+			list: g.ListUserConfigNamesContext,
+			want: []string{"bar", "foo"},
+		},
+		{
+			name: "cloud-init",
+			list: g.ListCloudInitConfigContext,
+			want: []string{"cloud-init.vendor"},
+		},
+		{
+			name: "no match",
+			list: func(ctx context.Context) ([]string, error) {
+				return g.ListConfigByPrefixContext(ctx, "user.missing")
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.list(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := g.ListUserConfigContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ListUserConfigContext with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func BenchmarkConfig(b *testing.B) {
+	srv := guesttest.NewServer(guesttest.Options{
+		Config: map[string]string{
+			"user.foo": strings.Repeat("x", 256),
+		},
+	})
+	defer srv.Close()
+
+	g := srv.Client()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := g.Config("foo"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHasConfigAllContext(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{"user.foo": "1", "user.bar": "2"},
+	})
+	g := srv.Client()
+	ctx := context.Background()
+
+	got, err := g.HasConfigAllContext(ctx, "foo", "bar", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"foo": true, "bar": true, "missing": false}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := g.HasConfigAllContext(cancelled, "foo", "bar"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("HasConfigAllContext with cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestConfigEnviron(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{
+			"user.db-host":      "db.example.com",
+			"user.port":         "5432",
+			"cloud-init.vendor": "#cloud-config",
+		},
+	})
+
+	// Record the config values requested, to check that
+	// only user keys are fetched.
+	var mu sync.Mutex
+	var fetched []string
+	record := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if key, ok := strings.CutPrefix(req.URL.Path, "/1.0/config/"); ok {
+				mu.Lock()
+				fetched = append(fetched, key)
+				mu.Unlock()
+			}
+			return next.RoundTrip(req)
+		})
+	}
+
+	g := guest.NewClient(guest.WithSocketPath(srv.SocketPath), guest.WithMiddleware(record))
+
+	env, err := g.ConfigEnvironContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"DB_HOST=db.example.com", "PORT=5432"}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("got %v, want %v", env, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, key := range fetched {
+		if !strings.HasPrefix(key, "user.") {
+			t.Fatalf("fetched %s, want only user keys fetched", key)
+		}
+	}
+}
+
+func TestConfigEnvironConflict(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{
+			"user.db-host": "a",
+			"user.db_host": "b",
+		},
+	})
+	g := srv.Client()
+
+	env, err := g.ConfigEnviron()
+	if !errors.Is(err, guest.ErrEnvNameConflict) {
+		t.Fatalf("got %v, %v, want ErrEnvNameConflict", env, err)
+	}
+
+	// Leaving one of the keys out resolves the conflict.
+	env, err = g.ConfigEnvironFunc(func(key string) string {
+		if key == "user.db_host" {
+			return ""
+		}
+		return guest.EnvName(key)
+	})
+	if err != nil || !reflect.DeepEqual(env, []string{"DB_HOST=a"}) {
+		t.Fatalf("got %v, %v, want DB_HOST=a", env, err)
+	}
+}
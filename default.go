@@ -0,0 +1,43 @@
+package guest
+
+import (
+	"sync"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+var (
+	defaultClient     *GuestClient
+	defaultClientOnce sync.Once
+)
+
+// DefaultClient returns a client with the default options, created
+// the first time it's needed. It's used by the package-level
+// functions, such as Config, and is shared by all callers.
+func DefaultClient() *GuestClient {
+	defaultClientOnce.Do(func() {
+		defaultClient = NewClient()
+	})
+
+	return defaultClient
+}
+
+// Config calls Config on the default client.
+func Config(key string) (string, error) {
+	return DefaultClient().Config(key)
+}
+
+// HasConfig calls HasConfig on the default client.
+func HasConfig(key string) (bool, error) {
+	return DefaultClient().HasConfig(key)
+}
+
+// Info calls Info on the default client.
+func Info() (*incus.InstanceInfo, error) {
+	return DefaultClient().Info()
+}
+
+// Devices calls Devices on the default client.
+func Devices() (map[string]map[string]string, error) {
+	return DefaultClient().Devices()
+}
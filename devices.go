@@ -0,0 +1,70 @@
+package guest
+
+import (
+	"context"
+	"slices"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// DevicesTyped is like Devices, but converts each device into
+// one of the typed structs in the incus package.
+//
+// See incus.ParseDevice.
+func (g *GuestClient) DevicesTyped() (map[string]incus.Device, error) {
+	return g.DevicesTypedContext(context.Background())
+}
+
+// DevicesTypedContext is like DevicesTyped but takes a context.
+func (g *GuestClient) DevicesTypedContext(ctx context.Context) (map[string]incus.Device, error) {
+	devices, err := g.DevicesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]incus.Device, len(devices))
+	for name, props := range devices {
+		out[name] = incus.ParseDevice(props)
+	}
+
+	return out, nil
+}
+
+// GetDevicesByType is like Devices, but only returns devices of type t.
+func (g *GuestClient) GetDevicesByType(t string) (map[string]map[string]string, error) {
+	devices, err := g.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]map[string]string)
+	for name, props := range devices {
+		if props["type"] == t {
+			out[name] = props
+		}
+	}
+
+	return out, nil
+}
+
+// DeviceTypes returns the distinct types of the devices
+// attached to the instance, in sorted order.
+func (g *GuestClient) DeviceTypes() ([]string, error) {
+	devices, err := g.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	types := []string{}
+	for _, props := range devices {
+		t := props["type"]
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	slices.Sort(types)
+
+	return types, nil
+}
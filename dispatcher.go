@@ -0,0 +1,170 @@
+package guest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// SubscriberPolicy determines what an EventDispatcher does when
+// a subscriber's channel is full.
+type SubscriberPolicy int
+
+const (
+	// SubscriberDrop discards the event for that subscriber.
+	SubscriberDrop SubscriberPolicy = iota
+
+	// SubscriberClose unsubscribes the subscriber, closing
+	// its channel.
+	SubscriberClose
+)
+
+// EventDispatcher shares a single events connection between any
+// number of subscribers, each receiving every event on its own
+// buffered channel. A slow subscriber never holds up the others.
+//
+// Every subscriber and device handler receives the same *incus.Event,
+// so events must be treated as read-only. Copy an event before
+// modifying it.
+type EventDispatcher struct {
+	g          *GuestClient
+	bufferSize int
+	policy     SubscriberPolicy
+
+	mu             sync.Mutex
+	done           bool
+	subs           map[<-chan *incus.Event]chan *incus.Event
+	deviceHandlers map[incus.DeviceAction][]func(incus.DeviceUpdateMetadata)
+}
+
+// NewEventDispatcher returns a dispatcher for events received by g.
+// Each subscriber's channel buffers up to bufferSize events, and
+// policy decides what happens when it's full.
+func NewEventDispatcher(g *GuestClient, bufferSize int, policy SubscriberPolicy) *EventDispatcher {
+	return &EventDispatcher{
+		g:              g,
+		bufferSize:     bufferSize,
+		policy:         policy,
+		subs:           make(map[<-chan *incus.Event]chan *incus.Event),
+		deviceHandlers: make(map[incus.DeviceAction][]func(incus.DeviceUpdateMetadata)),
+	}
+}
+
+// Subscribe returns a channel that receives every event handled
+// by the dispatcher. It's closed when Run returns or the channel
+// is passed to Unsubscribe. Once Run has returned, Subscribe
+// returns a closed channel.
+func (d *EventDispatcher) Subscribe() <-chan *incus.Event {
+	ch := make(chan *incus.Event, d.bufferSize)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.done {
+		close(ch)
+		return ch
+	}
+
+	d.subs[ch] = ch
+
+	return ch
+}
+
+// Unsubscribe stops sending events to ch and closes it.
+func (d *EventDispatcher) Unsubscribe(ch <-chan *incus.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if sub, ok := d.subs[ch]; ok {
+		close(sub)
+		delete(d.subs, ch)
+	}
+}
+
+// Run listens for events, blocking the current goroutine and sending
+// each event to every subscriber until ctx is done or the connection
+// fails. Event types are handled as in ListenForEvents. When Run
+// returns, all subscriber channels are closed.
+func (d *EventDispatcher) Run(ctx context.Context, events ...incus.EventType) error {
+	d.mu.Lock()
+	d.done = false
+	d.mu.Unlock()
+
+	defer d.closeAll()
+
+	return d.g.handleEvents(ctx, listenHooks{}, func(ev *incus.Event) error {
+		d.publish(ev)
+		d.handleDevice(ev)
+		return nil
+	}, events...)
+}
+
+// OnDeviceAdded registers fn to be called by Run for each device
+// event reporting that a device was added.
+func (d *EventDispatcher) OnDeviceAdded(fn func(incus.DeviceUpdateMetadata)) {
+	d.onDevice(incus.DeviceActionAdd, fn)
+}
+
+// OnDeviceRemoved registers fn to be called by Run for each device
+// event reporting that a device was removed.
+func (d *EventDispatcher) OnDeviceRemoved(fn func(incus.DeviceUpdateMetadata)) {
+	d.onDevice(incus.DeviceActionRemove, fn)
+}
+
+// OnDeviceUpdated registers fn to be called by Run for each device
+// event reporting that a device's config changed.
+func (d *EventDispatcher) OnDeviceUpdated(fn func(incus.DeviceUpdateMetadata)) {
+	d.onDevice(incus.DeviceActionUpdate, fn)
+}
+
+func (d *EventDispatcher) onDevice(action incus.DeviceAction, fn func(incus.DeviceUpdateMetadata)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deviceHandlers[action] = append(d.deviceHandlers[action], fn)
+}
+
+// handleDevice calls the handlers registered for the action of a
+// device event, in the order they were registered. They're called
+// after the event is sent to subscribers.
+func (d *EventDispatcher) handleDevice(ev *incus.Event) {
+	if ev.Type != incus.EventTypeDevice {
+		return
+	}
+
+	d.mu.Lock()
+	handlers := d.deviceHandlers[ev.Device.ActionType]
+	d.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(ev.Device)
+	}
+}
+
+func (d *EventDispatcher) publish(ev *incus.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, sub := range d.subs {
+		select {
+		case sub <- ev:
+		default:
+			if d.policy == SubscriberClose {
+				close(sub)
+				delete(d.subs, key)
+			}
+		}
+	}
+}
+
+func (d *EventDispatcher) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.done = true
+	for key, sub := range d.subs {
+		close(sub)
+		delete(d.subs, key)
+	}
+}
@@ -0,0 +1,100 @@
+package guest_test
+
+import (
+	"context"
+	"testing"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestDispatcherSubscribeAfterRun(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	onConnect, connected := notifyConnect()
+	g := guest.NewClient(guest.WithSocketPath(srv.SocketPath), onConnect)
+
+	d := guest.NewEventDispatcher(g, 4, guest.SubscriberDrop)
+	before := d.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- d.Run(ctx)
+	}()
+	await(t, "connection", connected)
+
+	srv.SetConfig("user.foo", "bar")
+	if ev := await(t, "event", before); ev == nil || ev.Config.Key != "user.foo" {
+		t.Fatalf("got event %v, want config event for user.foo", ev)
+	}
+
+	cancel()
+	if err := await(t, "Run to return", errc); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	await(t, "channel subscribed before Run returned to close", closed(before))
+	await(t, "channel subscribed after Run returned to close", closed(d.Subscribe()))
+}
+
+// closed drains ch, returning a channel that's closed once ch is.
+func closed[T any](ch <-chan T) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func TestDispatcherIgnoresDeviceNameFilter(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	onConnect, connected := notifyConnect()
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		guest.WithDeviceNameFilter("eth0"),
+		onConnect,
+	)
+
+	evc := make(chan *incus.Event, 4)
+	listen(t, g, func(ev *incus.Event) {
+		evc <- ev
+	})
+	await(t, "connection", connected)
+
+	d := guest.NewEventDispatcher(g, 4, guest.SubscriberDrop)
+	added := make(chan string, 4)
+	d.OnDeviceAdded(func(dev incus.DeviceUpdateMetadata) {
+		added <- dev.Name
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- d.Run(ctx)
+	}()
+	await(t, "dispatcher connection", connected)
+
+	srv.AddDevice("disk0", map[string]string{"type": "disk", "path": "/mnt"})
+	srv.AddDevice("eth0", map[string]string{"type": "nic"})
+
+	for _, want := range []string{"disk0", "eth0"} {
+		if got := await(t, "device handler", added); got != want {
+			t.Fatalf("device handler got %s, want %s", got, want)
+		}
+	}
+
+	// The filter still applies to the caller's listener.
+	if ev := await(t, "event", evc); ev.Device.Name != "eth0" {
+		t.Fatalf("got event for %q, want only eth0", ev.Device.Name)
+	}
+
+	cancel()
+	if err := await(t, "Run to return", errc); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
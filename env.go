@@ -0,0 +1,103 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// EnvName is the default transform used by ConfigEnviron. It strips
+// the "user." prefix from key, uppercases it, and replaces anything
+// other than letters, digits and underscores with underscores, so
+// "user.db-host" becomes "DB_HOST".
+func EnvName(key string) string {
+	key = strings.TrimPrefix(key, "user.")
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+// ConfigEnviron returns every user.* config key as an environment
+// variable in the form "KEY=value", named by EnvName and sorted, for
+// use as exec.Cmd.Env. Only user.* values are fetched.
+func (g *GuestClient) ConfigEnviron() ([]string, error) {
+	return g.ConfigEnvironContext(context.Background())
+}
+
+// ConfigEnvironContext is like ConfigEnviron but takes a context.
+func (g *GuestClient) ConfigEnvironContext(ctx context.Context) ([]string, error) {
+	return g.ConfigEnvironFuncContext(ctx, EnvName)
+}
+
+// ConfigEnvironFunc is like ConfigEnviron, but names each variable
+// using transform, which is passed the full key. Keys for which it
+// returns an empty string are left out. If two keys are given the
+// same name, ErrEnvNameConflict is returned.
+func (g *GuestClient) ConfigEnvironFunc(transform func(key string) string) ([]string, error) {
+	return g.ConfigEnvironFuncContext(context.Background(), transform)
+}
+
+// ConfigEnvironFuncContext is like ConfigEnvironFunc but takes a context.
+func (g *GuestClient) ConfigEnvironFuncContext(ctx context.Context, transform func(key string) string) ([]string, error) {
+	keys, err := g.ListUserConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name every key before fetching any values, so that a
+	// conflict is reported without making the requests.
+	names := make(map[string]string, len(keys))
+	owners := make(map[string]string, len(keys))
+	fetch := []string{}
+	for _, key := range keys {
+		name := transform(key)
+		if name == "" {
+			continue
+		}
+
+		if other, ok := owners[name]; ok {
+			return nil, fmt.Errorf("%w: %s and %s are both named %s", ErrEnvNameConflict, other, key, name)
+		}
+
+		owners[name] = key
+		names[key] = name
+		fetch = append(fetch, key)
+	}
+
+	var mu sync.Mutex
+	env := make([]string, 0, len(names))
+
+	err = parallel(ctx, fetch, func(ctx context.Context, key string) error {
+		value, err := g.configValue(ctx, key)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			// Removed since we listed the keys.
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		env = append(env, names[key]+"="+value)
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(env)
+
+	return env, nil
+}
@@ -0,0 +1,114 @@
+package guest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	UnexpectedStatusCode = errors.New("unexpected status code")
+
+	// ErrConfigKeyNotFound is returned when a requested config
+	// key isn't set on the instance.
+	ErrConfigKeyNotFound = errors.New("config key not found")
+
+	// ErrNotInsideInstance is returned when the guest API socket
+	// doesn't exist or refuses connections, which usually means the
+	// program isn't running inside an Incus instance.
+	ErrNotInsideInstance = errors.New("not inside an incus instance")
+
+	// ErrCircuitOpen is returned without making a request while
+	// the circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+
+	// ErrInvalidConfigKey is returned without making a request
+	// when a config key is empty or contains whitespace, control
+	// characters or slashes.
+	ErrInvalidConfigKey = errors.New("invalid config key")
+
+	// ErrClientClosed is returned when a client is used
+	// after Close has been called.
+	ErrClientClosed = errors.New("client is closed")
+
+	// ErrResponseTooLarge is returned when a response body is
+	// larger than the limit set by WithMaxResponseBytes.
+	ErrResponseTooLarge = errors.New("response too large")
+
+	// ErrInvalidEventType is returned when subscribing to an
+	// unknown event type.
+	ErrInvalidEventType = errors.New("invalid event type")
+
+	// ErrReconnectFailed is returned by the event listeners when
+	// the connection can't be re-established within the number of
+	// attempts allowed.
+	ErrReconnectFailed = errors.New("failed to reconnect to events api")
+
+	// ErrStateRejected is returned when the guest API refuses
+	// a state reported by SetState.
+	ErrStateRejected = errors.New("state rejected")
+
+	// ErrEnvNameConflict is returned by ConfigEnviron when two
+	// config keys map to the same environment variable name.
+	ErrEnvNameConflict = errors.New("conflicting environment variable names")
+)
+
+// APIError is returned when the guest API responds with an
+// unexpected status code. It satisfies errors.Is(err, UnexpectedStatusCode).
+type APIError struct {
+	StatusCode int
+	Path       string
+
+	// Body is the body of the response, which usually
+	// describes the error.
+	Body []byte
+
+	// Message is the error reported by the guest API, taken from
+	// the body, or empty if there's none.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %d (%s): %s", UnexpectedStatusCode, e.StatusCode, e.Path, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %d (%s)", UnexpectedStatusCode, e.StatusCode, e.Path)
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == UnexpectedStatusCode
+}
+
+// newAPIError builds an APIError from resp, reading its body.
+func (g *GuestClient) newAPIError(resp *http.Response) error {
+	body, _ := g.readBody(resp)
+
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Path:       resp.Request.URL.Path,
+		Body:       body,
+		Message:    errorMessage(body),
+	}
+}
+
+// errorMessage returns the error described by the body of an error
+// response. Incus normally responds with a JSON object holding the
+// message in its error field, but the guest API may use plain text.
+func errorMessage(body []byte) string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) == nil {
+		return resp.Error
+	}
+
+	if !utf8.Valid(body) {
+		return ""
+	}
+
+	return strings.TrimSpace(string(body))
+}
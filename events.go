@@ -0,0 +1,657 @@
+package guest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+	"nhooyr.io/websocket"
+)
+
+// ListenForEvents opens a WebSocket connection to the guest events API, blocking
+// the current goroutine. It takes a callback function and an optional list of events
+// to subscribe to. If no events are provided, or incus.EventTypeAll is, it will
+// subscribe to all of them. An invalid event type returns ErrInvalidEventType.
+//
+// Events are passed to the callback one at a time, in the order they're
+// received, and the next event isn't read until the callback returns. Use
+// WithConcurrentCallbacks to run each callback in its own goroutine instead,
+// in which case no ordering is guaranteed.
+//
+// Once ctx is done, ListenForEvents returns nil, even if the connection
+// fails while it's being closed.
+//
+// See the definition for incus.EventType for valid values.
+func (g *GuestClient) ListenForEvents(ctx context.Context, callback func(*incus.Event), events ...incus.EventType) error {
+	call := func(ev *incus.Event) {
+		defer g.recoverCallback(ev)
+		callback(ev)
+	}
+
+	return g.HandleEvents(ctx, func(ev *incus.Event) error {
+		if g.concurrentCallbacks {
+			go call(ev)
+		} else {
+			call(ev)
+		}
+		return nil
+	}, events...)
+}
+
+// HandleEvents is like ListenForEvents, but the handler is called for
+// each event in turn and can return an error to stop listening. When it
+// does, the connection is closed and HandleEvents returns that error.
+//
+// For both, a panic in the callback is recovered and logged, and the
+// event is skipped. Use WithPropagatePanics to let panics through.
+func (g *GuestClient) HandleEvents(ctx context.Context, handler func(*incus.Event) error, events ...incus.EventType) error {
+	return g.handleEvents(ctx, listenHooks{keep: g.keepEvent}, handler, events...)
+}
+
+// handleEvents is like HandleEvents, but calls hooks as the
+// connection is lost and reopened.
+func (g *GuestClient) handleEvents(ctx context.Context, hooks listenHooks, handler func(*incus.Event) error, events ...incus.EventType) error {
+	conn, err := g.dialEvents(ctx, events)
+	if err != nil {
+		return err
+	}
+
+	return g.dispatch(ctx, conn, events, hooks, func(ev *incus.Event) error {
+		defer g.recoverCallback(ev)
+		return handler(ev)
+	})
+}
+
+// recoverCallback recovers from a panic in an event callback, logging
+// it so the event loop can continue, unless the client is configured
+// to propagate panics. It must be deferred.
+func (g *GuestClient) recoverCallback(ev *incus.Event) {
+	if g.propagatePanics {
+		return
+	}
+
+	if r := recover(); r != nil {
+		g.logger.Error("recovered panic in event callback", "panic", r, "event", ev, "stack", string(debug.Stack()))
+	}
+}
+
+// Events opens a WebSocket connection to the guest events API and returns
+// a channel of received events, along with a channel that receives any error
+// that ends the stream. Both channels are closed once ctx is done or the
+// connection fails. Event types are handled as in ListenForEvents.
+//
+// An error is returned directly if the connection can't be opened.
+func (g *GuestClient) Events(ctx context.Context, events ...incus.EventType) (<-chan *incus.Event, <-chan error, error) {
+	return g.events(ctx, listenHooks{keep: g.keepEvent}, events...)
+}
+
+// events is like Events, but calls hooks as the connection is
+// lost and reopened.
+func (g *GuestClient) events(ctx context.Context, hooks listenHooks, events ...incus.EventType) (<-chan *incus.Event, <-chan error, error) {
+	conn, err := g.dialEvents(ctx, events)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	evc := make(chan *incus.Event)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(evc)
+
+		err := g.dispatch(ctx, conn, events, hooks, func(ev *incus.Event) error {
+			select {
+			case evc <- ev:
+			case <-ctx.Done():
+			}
+			return nil
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return evc, errc, nil
+}
+
+// BufferPolicy determines what happens when the event buffer is full.
+type BufferPolicy int
+
+const (
+	// BufferBlock stops reading from the connection until
+	// there's room in the buffer.
+	BufferBlock BufferPolicy = iota
+
+	// BufferDropOldest discards the oldest buffered event to
+	// make room for the new one.
+	BufferDropOldest
+)
+
+// DroppedEvents returns the number of events discarded because
+// the event buffer was full.
+func (g *GuestClient) DroppedEvents() uint64 {
+	return g.droppedEvents.Load()
+}
+
+// dispatch passes events read from conn to handle, via a buffer
+// if the client is configured with one.
+func (g *GuestClient) dispatch(ctx context.Context, conn *websocket.Conn, events []incus.EventType, hooks listenHooks, handle func(*incus.Event) error) error {
+	if g.eventBuffer <= 0 {
+		return g.listen(ctx, conn, events, hooks, handle)
+	}
+
+	// A handler error cancels ctx with the error as the cause, so
+	// that listen reports it rather than a closed connection.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	buf := make(chan *incus.Event, g.eventBuffer)
+	done := make(chan error, 1)
+
+	go func() {
+		for ev := range buf {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if err := handle(ev); err != nil {
+				cancel(&callbackError{err})
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	err := g.listen(ctx, conn, events, hooks, func(ev *incus.Event) error {
+		for {
+			select {
+			case buf <- ev:
+				return nil
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+
+			if g.bufferPolicy == BufferBlock {
+				select {
+				case buf <- ev:
+				case <-ctx.Done():
+				}
+				return nil
+			}
+
+			select {
+			case <-buf:
+				g.droppedEvents.Add(1)
+				if g.metrics != nil {
+					g.metrics.EventDropped()
+				}
+			default:
+			}
+		}
+	})
+	close(buf)
+
+	if handlerErr := <-done; handlerErr != nil {
+		return handlerErr
+	}
+
+	return err
+}
+
+const (
+	// defaultReconnectBackoff is the initial delay between
+	// reconnection attempts if none is set.
+	defaultReconnectBackoff = time.Second
+
+	// minReconnectBackoff is the shortest initial delay allowed, so
+	// that a failing connection isn't redialed in a tight loop.
+	minReconnectBackoff = 10 * time.Millisecond
+
+	// maxReconnectBackoff caps the delay between reconnection attempts.
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// listenHooks are called by a single listener as its connection
+// is lost and reopened, in addition to the client's hooks.
+type listenHooks struct {
+	// reconnected is called each time the connection is reopened,
+	// before any events are read from it.
+	reconnected func()
+
+	// disconnected is called each time the connection is closed,
+	// with the error that closed it, if any.
+	disconnected func(error)
+
+	// keep, if set, is called for each event before it's handled,
+	// and the event is skipped if it returns false. It's set to apply
+	// the client's event filters to the listeners started by callers,
+	// but not to those the client runs itself.
+	keep func(*incus.Event) bool
+}
+
+// listen reads events from conn until ctx is done or an error occurs,
+// redialing if the client is configured to reconnect. The connection
+// is closed on return.
+func (g *GuestClient) listen(ctx context.Context, conn *websocket.Conn, events []incus.EventType, hooks listenHooks, handle func(*incus.Event) error) (err error) {
+	dedup := &deduper{window: g.dedupWindow}
+
+	ctx, span := g.startSpan(ctx, "guest events")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}()
+
+	for {
+		err := g.readEvents(ctx, conn, dedup, hooks.keep, handle)
+		conn.CloseNow()
+
+		// With a buffer, a handler error is the cause of ctx
+		// being done rather than the result of the read.
+		var cbErr *callbackError
+		if errors.As(err, &cbErr) || errors.As(context.Cause(ctx), &cbErr) {
+			g.disconnected(hooks, cbErr.err)
+			return cbErr.err
+		}
+
+		if ctx.Err() != nil {
+			err = nil
+		}
+
+		g.disconnected(hooks, err)
+
+		if err == nil || !g.reconnect {
+			return err
+		}
+
+		g.logger.Debug("events connection lost", "error", err)
+
+		for attempt := 1; ; attempt++ {
+			if g.maxReconnects > 0 && attempt > g.maxReconnects {
+				g.logger.Debug("giving up reconnecting to events api", "attempts", attempt-1)
+				return fmt.Errorf("%w after %d attempts: %w", ErrReconnectFailed, attempt-1, err)
+			}
+
+			delay := g.backoff(attempt)
+			g.logger.Debug("reconnecting to events api", "attempt", attempt, "delay", delay)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+
+			var dialErr error
+			conn, dialErr = g.dialEvents(ctx, events)
+			if dialErr == nil {
+				break
+			}
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			g.logger.Debug("reconnection attempt failed", "attempt", attempt, "error", dialErr)
+			err = dialErr
+		}
+
+		g.logger.Debug("reconnected to events api")
+		span.AddEvent("reconnected")
+		if g.metrics != nil {
+			g.metrics.Reconnected()
+		}
+
+		if g.onReconnect != nil {
+			g.onReconnect()
+		}
+
+		if hooks.reconnected != nil {
+			hooks.reconnected()
+		}
+	}
+}
+
+// disconnected calls the OnDisconnect hook and the listener's
+// disconnected hook, if set.
+func (g *GuestClient) disconnected(hooks listenHooks, err error) {
+	if g.onDisconnect != nil {
+		g.onDisconnect(err)
+	}
+
+	if hooks.disconnected != nil {
+		hooks.disconnected(err)
+	}
+}
+
+// backoff returns how long to wait before the given reconnection
+// attempt, doubling each time with up to half the delay as jitter.
+func (g *GuestClient) backoff(attempt int) time.Duration {
+	d := g.reconnectBackoff
+	for i := 1; i < attempt && d < maxReconnectBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxReconnectBackoff)
+
+	return d/2 + rand.N(d/2+1)
+}
+
+// subscriptionTypes validates the requested event types, returning
+// those to subscribe to, or none if every type should be received.
+func subscriptionTypes(events []incus.EventType) ([]incus.EventType, error) {
+	types := []incus.EventType{}
+	for _, ev := range events {
+		if ev == incus.EventTypeAll {
+			return nil, nil
+		}
+
+		if !ev.Valid() {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidEventType, ev)
+		}
+
+		types = append(types, ev)
+	}
+
+	return types, nil
+}
+
+// dialEvents opens a connection to the events API, subscribed
+// to the provided event types.
+func (g *GuestClient) dialEvents(ctx context.Context, events []incus.EventType) (*websocket.Conn, error) {
+	if g.closed.Load() {
+		return nil, ErrClientClosed
+	}
+
+	endpoint, err := url.JoinPath("ws://", EventsPath)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error: %w", err)
+	}
+
+	types, err := subscriptionTypes(events)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only subscribe to specific events
+	if len(types) > 0 {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected error: %w", err)
+		}
+
+		strEvents := []string{}
+		for _, ev := range types {
+			strEvents = append(strEvents, string(ev))
+		}
+
+		val := url.Values{}
+		val.Add("type", strings.Join(strEvents, ","))
+		parsed.RawQuery = val.Encode()
+		endpoint = parsed.String()
+	}
+
+	g.logger.Debug("connecting to events api", "endpoint", endpoint)
+
+	conn, _, err := websocket.Dial(ctx, endpoint, &websocket.DialOptions{
+		HTTPClient: g.c,
+	})
+	if err != nil {
+		g.logger.Debug("events connection failed", "error", err)
+		return nil, classifyDialError(err)
+	}
+
+	if g.onConnect != nil {
+		g.onConnect()
+	}
+
+	if g.onSubscribe != nil {
+		if len(types) == 0 {
+			types = []incus.EventType{incus.EventTypeConfig, incus.EventTypeDevice}
+		}
+		g.onSubscribe(types)
+	}
+
+	return conn, nil
+}
+
+// keepalive pings conn every ping interval until done is closed. If
+// a pong isn't received within the interval, the ping error is sent
+// on failed and the connection is closed so that the pending read fails.
+//
+// Pongs are only read while the listener is reading from conn, so a
+// ping that times out while it was held up by a handler, as reported
+// by readingSince, is sent again rather than treated as a failure.
+func (g *GuestClient) keepalive(conn *websocket.Conn, readingSince *atomic.Int64, done <-chan struct{}, failed chan<- error) {
+	ticker := time.NewTicker(g.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now().UnixNano()
+
+		ctx, cancel := context.WithTimeout(context.Background(), g.pingInterval)
+		err := conn.Ping(ctx)
+		cancel()
+
+		if since := readingSince.Load(); errors.Is(err, context.DeadlineExceeded) && (since == 0 || since > sent) {
+			g.logger.Debug("events keepalive ping unanswered while handling an event")
+			continue
+		}
+
+		if err != nil {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			g.logger.Debug("events keepalive ping failed", "error", err)
+			failed <- err
+			conn.CloseNow()
+			return
+		}
+	}
+}
+
+// received records ev as the last event received.
+func (g *GuestClient) received(ev *incus.Event) {
+	g.lastEventMu.Lock()
+	defer g.lastEventMu.Unlock()
+
+	g.lastEvent = ev
+	g.lastEventAt = time.Now()
+}
+
+// LastEvent returns the last event received by any of the client's
+// event listeners, before filtering, and when it was received. It
+// returns nil and the zero time if no event has been received.
+func (g *GuestClient) LastEvent() (*incus.Event, time.Time) {
+	g.lastEventMu.Lock()
+	defer g.lastEventMu.Unlock()
+
+	return g.lastEvent, g.lastEventAt
+}
+
+// deduper detects repeated config events received by a single
+// listener. Each listener has its own, as every listener receives
+// a copy of each event.
+type deduper struct {
+	window time.Duration
+	last   incus.ConfigUpdateMetadata
+	lastAt time.Time
+}
+
+// duplicate reports whether ev is a config event identical to the
+// previous one, received within the dedup window.
+func (d *deduper) duplicate(ev *incus.Event) bool {
+	if d.window <= 0 || ev.Type != incus.EventTypeConfig {
+		return false
+	}
+
+	now := time.Now()
+	dup := ev.Config == d.last && now.Sub(d.lastAt) < d.window
+
+	d.last = ev.Config
+	d.lastAt = now
+
+	return dup
+}
+
+// SuppressedEvents returns the number of duplicate config events
+// discarded because of WithEventDedup.
+func (g *GuestClient) SuppressedEvents() uint64 {
+	return g.suppressedEvents.Load()
+}
+
+// keepEvent reports whether ev passes the client's event filters.
+func (g *GuestClient) keepEvent(ev *incus.Event) bool {
+	for _, keep := range g.eventFilters {
+		if !keep(ev) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// callbackError wraps an error returned by an event handler so
+// it isn't mistaken for a connection failure.
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string {
+	return e.err.Error()
+}
+
+// readEvents reads events from conn until ctx is done or an
+// error occurs, passing each accepted by keep to handle.
+func (g *GuestClient) readEvents(ctx context.Context, conn *websocket.Conn, dedup *deduper, keep func(*incus.Event) bool, handle func(*incus.Event) error) error {
+	// Cancelling a read aborts the connection without a close frame,
+	// so reads aren't cancelled with ctx. Instead, once ctx is done
+	// the connection is closed normally, which ends any pending read.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close(websocket.StatusNormalClosure, "")
+	})
+	defer stop()
+
+	readCtx := context.WithoutCancel(ctx)
+
+	// readingSince holds when the loop last went back to reading
+	// from conn, or zero while it's held up handling an event.
+	var readingSince atomic.Int64
+	readingSince.Store(time.Now().UnixNano())
+
+	pingFailed := make(chan error, 1)
+	if g.pingInterval > 0 {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+
+		go func() {
+			defer close(stopped)
+			g.keepalive(conn, &readingSince, done, pingFailed)
+		}()
+	}
+
+	// Each message is read into the same buffer. Parsing copies
+	// anything it keeps, so the data isn't needed afterwards.
+	var buf bytes.Buffer
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			buf.Reset()
+			err := readMessage(readCtx, conn, &buf)
+			if err != nil {
+				// Once ctx is done, any read error is the result of
+				// closing the connection, however the read failed.
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				select {
+				case pingErr := <-pingFailed:
+					return fmt.Errorf("keepalive ping failed: %w", pingErr)
+				default:
+				}
+
+				return fmt.Errorf("error in reader: %w", err)
+			}
+
+			parse := incus.ParseEvent
+			if g.strictDecoding {
+				parse = incus.ParseEventStrict
+			}
+
+			data := buf.Bytes()
+			ev, err := parse(data)
+			if err != nil {
+				if !g.skipBadEvents {
+					return fmt.Errorf("error in json unmarshaller: %w", err)
+				}
+
+				g.logger.Debug("skipping malformed event", "error", err)
+				if g.onBadEvent != nil {
+					g.onBadEvent(bytes.Clone(data), err)
+				}
+				continue
+			}
+
+			g.received(ev)
+			if g.metrics != nil {
+				g.metrics.EventReceived(ev.Type)
+			}
+			g.invalidateFor(ev)
+
+			if dedup.duplicate(ev) {
+				g.suppressedEvents.Add(1)
+				continue
+			}
+
+			if keep != nil && !keep(ev) {
+				continue
+			}
+
+			readingSince.Store(0)
+			err = handle(ev)
+			readingSince.Store(time.Now().UnixNano())
+
+			if err != nil {
+				return &callbackError{err}
+			}
+		}
+	}
+}
+
+// readMessage reads the next message from conn into buf.
+func readMessage(ctx context.Context, conn *websocket.Conn, buf *bytes.Buffer) error {
+	_, r, err := conn.Reader(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.ReadFrom(r)
+	return err
+}
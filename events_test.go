@@ -0,0 +1,475 @@
+package guest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+	"github.com/shellhazard/incus-guestapi/incus"
+	"nhooyr.io/websocket"
+)
+
+// waitFor polls cond until it's true, failing the test
+// if that takes longer than a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// notifyConnect returns an option making the client signal the
+// returned channel each time an events connection is opened.
+func notifyConnect() (guest.Option, <-chan struct{}) {
+	connected := make(chan struct{}, 16)
+
+	return guest.WithOnConnect(func() {
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	}), connected
+}
+
+// await waits for a value on ch, failing the test if that
+// takes longer than a few seconds.
+func await[T any](t *testing.T, what string, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+// listen runs ListenForEvents in the background until the test
+// finishes, returning a channel receiving its result.
+func listen(t *testing.T, g *guest.GuestClient, callback func(*incus.Event)) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errc <- g.ListenForEvents(ctx, callback)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return errc
+}
+
+// recordPeak raises peak to the current number of goroutines.
+func recordPeak(peak *atomic.Int64) {
+	n := int64(runtime.NumGoroutine())
+	for {
+		old := peak.Load()
+		if n <= old || peak.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+func TestEventBufferFlood(t *testing.T) {
+	const events = 2000
+
+	// flood sends every event as soon as the client connects,
+	// closing sent once they've all been written.
+	flood := func(sent chan<- struct{}, peak *atomic.Int64) func(context.Context, *websocket.Conn) {
+		return func(ctx context.Context, conn *websocket.Conn) {
+			for i := 0; i < events; i++ {
+				if err := conn.Write(ctx, websocket.MessageText, configEvent("user.flood", strconv.Itoa(i))); err != nil {
+					return
+				}
+				recordPeak(peak)
+			}
+			close(sent)
+		}
+	}
+
+	t.Run("block", func(t *testing.T) {
+		var handled, peak atomic.Int64
+		path := serveEvents(t, flood(make(chan struct{}), &peak))
+		g := guest.NewClient(guest.WithSocketPath(path), guest.WithEventBuffer(16))
+
+		baseline := runtime.NumGoroutine()
+
+		listen(t, g, func(ev *incus.Event) {
+			handled.Add(1)
+			recordPeak(&peak)
+			time.Sleep(50 * time.Microsecond)
+		})
+
+		waitFor(t, "events to be handled", func() bool { return handled.Load() == events })
+
+		if dropped := g.DroppedEvents(); dropped != 0 {
+			t.Fatalf("dropped %d events, want none", dropped)
+		}
+
+		if peak.Load() > int64(baseline+20) {
+			t.Fatalf("goroutines peaked at %d, from %d", peak.Load(), baseline)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		var handled, peak atomic.Int64
+		sent := make(chan struct{})
+		path := serveEvents(t, flood(sent, &peak))
+		g := guest.NewClient(
+			guest.WithSocketPath(path),
+			guest.WithEventBuffer(16),
+			guest.WithBufferPolicy(guest.BufferDropOldest),
+		)
+
+		baseline := runtime.NumGoroutine()
+
+		// Hold up the handler until every event has been sent,
+		// so the buffer overflows.
+		release := make(chan struct{})
+
+		listen(t, g, func(ev *incus.Event) {
+			<-release
+			handled.Add(1)
+			recordPeak(&peak)
+		})
+
+		await(t, "events to be sent", sent)
+		close(release)
+
+		waitFor(t, "every event to be handled or dropped", func() bool {
+			return uint64(handled.Load())+g.DroppedEvents() == events
+		})
+
+		if g.DroppedEvents() == 0 {
+			t.Fatal("no events dropped, want the buffer to overflow")
+		}
+
+		if peak.Load() > int64(baseline+20) {
+			t.Fatalf("goroutines peaked at %d, from %d", peak.Load(), baseline)
+		}
+	})
+}
+
+func TestEventsCancelDuringRead(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	g := srv.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evc, errc, err := g.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is sent, so the client is blocked reading.
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+
+	for range evc {
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("took %v to return after cancelling", elapsed)
+	}
+
+	waitFor(t, "the server to see the close", func() bool {
+		return srv.CloseStatus() != -1
+	})
+
+	if status := srv.CloseStatus(); status != websocket.StatusNormalClosure {
+		t.Fatalf("server saw close status %v, want %v", status, websocket.StatusNormalClosure)
+	}
+}
+
+func TestSkipBadEvents(t *testing.T) {
+	bad := `{"timestamp":"2024-01-02T03:04:05Z","type":"config","metadata":{"key":1}}`
+	good := `{"timestamp":"2024-01-02T03:04:05Z","type":"config","metadata":{"key":"user.foo","old_value":"","value":"bar"}}`
+
+	srv := newServer(t, guesttest.Options{
+		Events: []json.RawMessage{json.RawMessage(bad), json.RawMessage(good)},
+	})
+
+	type badEvent struct {
+		raw []byte
+		err error
+	}
+	badc := make(chan badEvent, 1)
+
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		guest.WithSkipBadEvents(func(raw []byte, err error) {
+			badc <- badEvent{raw, err}
+		}),
+	)
+
+	evc := make(chan *incus.Event, 1)
+	errc := listen(t, g, func(ev *incus.Event) {
+		evc <- ev
+	})
+
+	got := await(t, "bad event callback", badc)
+	if string(got.raw) != bad {
+		t.Fatalf("callback got %s, want %s", got.raw, bad)
+	}
+	if got.err == nil {
+		t.Fatal("callback got a nil error")
+	}
+
+	select {
+	case ev := <-evc:
+		if ev.Config.Key != "user.foo" || ev.Config.Value != "bar" {
+			t.Fatalf("got %+v, want config event for user.foo", ev.Config)
+		}
+	case err := <-errc:
+		t.Fatalf("ListenForEvents returned %v after a bad event", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the good event")
+	}
+}
+
+func TestPingWithBlockingHandler(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	onConnect, connected := notifyConnect()
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		guest.WithPingInterval(20*time.Millisecond),
+		onConnect,
+	)
+
+	handled := make(chan string, 2)
+	errc := listen(t, g, func(ev *incus.Event) {
+		// Block for several ping intervals.
+		if ev.Config.Value == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		handled <- ev.Config.Value
+	})
+	await(t, "connection", connected)
+
+	srv.SetConfig("user.foo", "slow")
+	srv.SetConfig("user.foo", "fast")
+
+	for _, want := range []string{"slow", "fast"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Fatalf("got event with value %q, want %q", got, want)
+			}
+		case err := <-errc:
+			t.Fatalf("ListenForEvents returned %v with a blocking handler", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event with value %q", want)
+		}
+	}
+
+	// The connection stays up once the handler is reading again.
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case err := <-errc:
+		t.Fatalf("ListenForEvents returned %v after a blocking handler", err)
+	default:
+	}
+}
+
+func TestReconnectZeroBackoff(t *testing.T) {
+	// Accept one events connection and close it straight away,
+	// then fail every attempt to redial.
+	var dials atomic.Int64
+	path := serveSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dials.Add(1) > 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close(websocket.StatusGoingAway, "")
+	}))
+
+	g := guest.NewClient(guest.WithSocketPath(path), guest.WithReconnect(0, 0))
+	listen(t, g, func(ev *incus.Event) {})
+
+	waitFor(t, "a redial", func() bool { return dials.Load() > 1 })
+	time.Sleep(200 * time.Millisecond)
+
+	if n := dials.Load(); n > 20 {
+		t.Fatalf("redialed %d times in 200ms, want the attempts spaced out", n-1)
+	}
+}
+
+func TestListenCancelDuringReads(t *testing.T) {
+	options := map[string][]guest.Option{
+		"plain":     nil,
+		"reconnect": {guest.WithReconnect(3, 10*time.Millisecond)},
+		"buffered":  {guest.WithEventBuffer(4)},
+	}
+
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t, guesttest.Options{})
+
+			// Keep events flowing so the cancellation lands at a
+			// different point of a read each time.
+			stop := make(chan struct{})
+			sent := make(chan struct{})
+			go func() {
+				defer close(sent)
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						srv.SetConfig("user.foo", strconv.Itoa(i))
+					}
+				}
+			}()
+			defer func() {
+				close(stop)
+				<-sent
+			}()
+
+			for i := 0; i < 10; i++ {
+				onConnect, connected := notifyConnect()
+				g := guest.NewClient(append([]guest.Option{guest.WithSocketPath(srv.SocketPath), onConnect}, opts...)...)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				errc := make(chan error, 1)
+				go func() {
+					errc <- g.ListenForEvents(ctx, func(ev *incus.Event) {})
+				}()
+
+				await(t, "connection", connected)
+				time.Sleep(time.Duration(i) * 200 * time.Microsecond)
+				cancel()
+
+				if err := await(t, "ListenForEvents to return", errc); err != nil {
+					t.Fatalf("iteration %d: got error %v, want nil", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerErrorDisconnect(t *testing.T) {
+	options := map[string][]guest.Option{
+		"plain":    nil,
+		"buffered": {guest.WithEventBuffer(4)},
+	}
+
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t, guesttest.Options{})
+
+			disconnected := make(chan error, 1)
+			onConnect, connected := notifyConnect()
+			g := guest.NewClient(append([]guest.Option{
+				guest.WithSocketPath(srv.SocketPath),
+				guest.WithOnDisconnect(func(err error) { disconnected <- err }),
+				onConnect,
+			}, opts...)...)
+
+			stop := errors.New("stop")
+			errc := make(chan error, 1)
+			go func() {
+				errc <- g.HandleEvents(context.Background(), func(ev *incus.Event) error {
+					return stop
+				})
+			}()
+			await(t, "connection", connected)
+
+			srv.SetConfig("user.foo", "bar")
+
+			if err := await(t, "HandleEvents to return", errc); !errors.Is(err, stop) {
+				t.Fatalf("HandleEvents: got %v, want the handler's error", err)
+			}
+
+			if err := await(t, "OnDisconnect", disconnected); !errors.Is(err, stop) {
+				t.Fatalf("OnDisconnect: got %v, want the handler's error", err)
+			}
+		})
+	}
+}
+
+func TestConfigKeyFilterSkipsWaitForConfig(t *testing.T) {
+	srv := newServer(t, guesttest.Options{})
+	onConnect, connected := notifyConnect()
+
+	// Signal each time WaitForConfig checks the key, so that it's
+	// only set once WaitForConfig has to rely on events.
+	checked := make(chan struct{}, 4)
+	notifyChecked := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if req.URL.Path == "/1.0/config/user.bootstrap" {
+				checked <- struct{}{}
+			}
+			return resp, err
+		})
+	}
+
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		guest.WithConfigKeyFilter("user.myapp."),
+		guest.WithMiddleware(notifyChecked),
+		onConnect,
+	)
+
+	evc := make(chan *incus.Event, 4)
+	listen(t, g, func(ev *incus.Event) {
+		evc <- ev
+	})
+	await(t, "connection", connected)
+
+	type result struct {
+		value string
+		err   error
+	}
+	waited := make(chan result, 1)
+	go func() {
+		value, err := g.WaitForConfig(context.Background(), "bootstrap")
+		waited <- result{value, err}
+	}()
+	await(t, "WaitForConfig to check the key", checked)
+
+	srv.SetConfig("user.bootstrap", "1")
+	srv.SetConfig("user.myapp.foo", "bar")
+
+	if got := await(t, "WaitForConfig to return", waited); got.err != nil || got.value != "1" {
+		t.Fatalf("WaitForConfig: got %q, %v, want 1", got.value, got.err)
+	}
+
+	// The filter still applies to the caller's listener.
+	if ev := await(t, "event", evc); ev.Config.Key != "user.myapp.foo" {
+		t.Fatalf("got event for %q, want only user.myapp.foo", ev.Config.Key)
+	}
+}
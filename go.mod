@@ -2,4 +2,7 @@ module github.com/shellhazard/incus-guestapi
 
 go 1.22.0
 
-require nhooyr.io/websocket v1.8.11
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	nhooyr.io/websocket v1.8.11
+)
@@ -0,0 +1,450 @@
+// Package guesttest provides a fake guest API for testing code
+// that uses the guest package, without a real Incus instance.
+package guesttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/incus"
+	"nhooyr.io/websocket"
+)
+
+// Options seeds the state of a Server.
+type Options struct {
+	Info     incus.InstanceInfo
+	Config   map[string]string
+	Devices  map[string]map[string]string
+	MetaData string
+
+	// Events are sent, in order, to each events connection
+	// as soon as it's opened.
+	Events []json.RawMessage
+}
+
+// Server is a fake guest API listening on a unix socket.
+type Server struct {
+	// SocketPath is the path of the socket the server listens on.
+	SocketPath string
+
+	srv    *httptest.Server
+	dir    string
+	client *guest.GuestClient
+
+	mu       sync.Mutex
+	info     incus.InstanceInfo
+	config   map[string]string
+	devices  map[string]map[string]string
+	metadata string
+	events   []json.RawMessage
+	streams  map[*stream]struct{}
+	closing  chan struct{}
+
+	closeStatus websocket.StatusCode
+}
+
+// stream is an open events connection.
+type stream struct {
+	ch   chan json.RawMessage
+	done chan struct{}
+	drop chan struct{}
+}
+
+// NewServer starts a Server seeded with opts. It panics if
+// the socket can't be created. Callers should call Close
+// when finished to shut it down.
+func NewServer(opts Options) *Server {
+	dir, err := os.MkdirTemp("", "guesttest")
+	if err != nil {
+		panic(fmt.Sprintf("guesttest: failed to create socket directory: %v", err))
+	}
+
+	socketPath := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("guesttest: failed to listen on %s: %v", socketPath, err))
+	}
+
+	s := &Server{
+		SocketPath: socketPath,
+		dir:        dir,
+		info:       opts.Info,
+		config:     make(map[string]string),
+		devices:    make(map[string]map[string]string),
+		metadata:   opts.MetaData,
+		events:     opts.Events,
+		streams:    make(map[*stream]struct{}),
+		closing:    make(chan struct{}),
+
+		closeStatus: -1,
+	}
+
+	if s.info.APIVersion == "" {
+		s.info.APIVersion = "1.0"
+	}
+
+	for k, v := range opts.Config {
+		s.config[k] = v
+	}
+
+	for name, props := range opts.Devices {
+		s.devices[name] = props
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleRoot)
+	mux.HandleFunc("GET /1.0", s.handleInfo)
+	mux.HandleFunc("PATCH /1.0", s.handleSetState)
+	mux.HandleFunc("GET /1.0/config", s.handleListConfig)
+	mux.HandleFunc("GET /1.0/config/{key}", s.handleConfig)
+	mux.HandleFunc("GET /1.0/devices", s.handleDevices)
+	mux.HandleFunc("GET /1.0/meta-data", s.handleMetaData)
+	mux.HandleFunc("GET /1.0/events", s.handleEvents)
+
+	s.srv = httptest.NewUnstartedServer(mux)
+	s.srv.Listener = l
+	s.srv.Start()
+
+	s.client = guest.NewClient(guest.WithSocketPath(socketPath))
+
+	return s
+}
+
+// Client returns a client connected to the server.
+func (s *Server) Client() *guest.GuestClient {
+	return s.client
+}
+
+// Close shuts down the server and removes its socket.
+func (s *Server) Close() {
+	close(s.closing)
+
+	s.client.Close()
+	s.srv.Close()
+	os.RemoveAll(s.dir)
+}
+
+// CloseStatus returns the status code of the close frame most
+// recently received from a client closing an events connection,
+// or -1 if no client has sent one.
+func (s *Server) CloseStatus() websocket.StatusCode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeStatus
+}
+
+// SetConfig sets a config key, sending a config event to any
+// open events connections.
+func (s *Server) SetConfig(key, value string) {
+	s.mu.Lock()
+	old := s.config[key]
+	s.config[key] = value
+	s.mu.Unlock()
+
+	s.SendEvent(newEvent(incus.EventTypeConfig, incus.ConfigUpdateMetadata{
+		Key:      key,
+		OldValue: old,
+		Value:    value,
+	}))
+}
+
+// DeleteConfig removes a config key, sending a config event to
+// any open events connections.
+func (s *Server) DeleteConfig(key string) {
+	s.mu.Lock()
+	old, ok := s.config[key]
+	delete(s.config, key)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	s.SendEvent(newEvent(incus.EventTypeConfig, incus.ConfigUpdateMetadata{
+		Key:      key,
+		OldValue: old,
+	}))
+}
+
+// AddDevice adds a device, sending a device event to any open
+// events connections.
+func (s *Server) AddDevice(name string, props map[string]string) {
+	s.mu.Lock()
+	s.devices[name] = props
+	s.mu.Unlock()
+
+	s.SendEvent(newEvent(incus.EventTypeDevice, map[string]any{
+		"name":   name,
+		"action": incus.DeviceActionAdd,
+		"config": props,
+	}))
+}
+
+// RemoveDevice removes a device, sending a device event to any
+// open events connections.
+func (s *Server) RemoveDevice(name string) {
+	s.mu.Lock()
+	props, ok := s.devices[name]
+	delete(s.devices, name)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	s.SendEvent(newEvent(incus.EventTypeDevice, map[string]any{
+		"name":   name,
+		"action": incus.DeviceActionRemove,
+		"config": props,
+	}))
+}
+
+// DropConnections closes any open events connections, leaving the
+// server running, to simulate the connection being lost.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for st := range s.streams {
+		close(st.drop)
+		delete(s.streams, st)
+	}
+}
+
+// SendEvent sends a raw event to any open events connections
+// subscribed to its type, blocking until each has accepted it.
+func (s *Server) SendEvent(raw json.RawMessage) {
+	s.mu.Lock()
+	streams := make([]*stream, 0, len(s.streams))
+	for st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		select {
+		case st.ch <- raw:
+		case <-st.done:
+		}
+	}
+}
+
+// newEvent builds a raw event with the current time.
+func newEvent(t incus.EventType, metadata any) json.RawMessage {
+	raw, err := json.Marshal(map[string]any{
+		"timestamp": time.Now().Format(time.RFC3339Nano),
+		"type":      t,
+		"metadata":  metadata,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("guesttest: failed to marshal event: %v", err))
+	}
+
+	return raw
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response in the format used by Incus.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"type":       "error",
+		"error":      msg,
+		"error_code": code,
+	})
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []string{"/1.0"})
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, s.info)
+}
+
+func (s *Server) handleSetState(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		State string `json:"state"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.State != "Started" && req.State != "Ready" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid state %q", req.State))
+		return
+	}
+
+	s.mu.Lock()
+	s.info.State = req.State
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]any{})
+}
+
+func (s *Server) handleListConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for k := range s.config {
+		if strings.HasPrefix(k, "user.") || strings.HasPrefix(k, "cloud-init.") {
+			keys = append(keys, "/1.0/config/"+k)
+		}
+	}
+	slices.Sort(keys)
+
+	writeJSON(w, keys)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !strings.HasPrefix(key, "user.") && !strings.HasPrefix(key, "cloud-init.") {
+		writeError(w, http.StatusForbidden, "not authorized")
+		return
+	}
+
+	s.mu.Lock()
+	value, ok := s.config[key]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(value))
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, s.devices)
+}
+
+func (s *Server) handleMetaData(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(s.metadata))
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if t := r.URL.Query().Get("type"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	st := &stream{
+		ch:   make(chan json.RawMessage),
+		done: make(chan struct{}),
+		drop: make(chan struct{}),
+	}
+
+	// Register the stream before completing the handshake, so that
+	// anything sent once the client's dial returns is delivered.
+	s.mu.Lock()
+	scripted := slices.Clone(s.events)
+	s.streams[st] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, st)
+		s.mu.Unlock()
+		close(st.done)
+	}()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Nothing is expected from the client, so this only watches
+	// for the connection closing, recording how it was closed.
+	go func() {
+		defer cancel()
+
+		for {
+			_, _, err := conn.Read(ctx)
+			if err == nil {
+				continue
+			}
+
+			if status := websocket.CloseStatus(err); status != -1 {
+				s.mu.Lock()
+				s.closeStatus = status
+				s.mu.Unlock()
+			}
+			return
+		}
+	}()
+
+	send := func(raw json.RawMessage) error {
+		if len(types) > 0 {
+			var ev struct {
+				Type string `json:"type"`
+			}
+			if json.Unmarshal(raw, &ev) == nil && !slices.Contains(types, ev.Type) {
+				return nil
+			}
+		}
+
+		return conn.Write(ctx, websocket.MessageText, raw)
+	}
+
+	for _, raw := range scripted {
+		if err := send(raw); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.closing:
+			conn.Close(websocket.StatusGoingAway, "server closed")
+			return
+		case <-st.drop:
+			conn.Close(websocket.StatusGoingAway, "connection dropped")
+			return
+		case raw := <-st.ch:
+			if err := send(raw); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package guesttest_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestServer(t *testing.T) {
+	srv := guesttest.NewServer(guesttest.Options{
+		Info: incus.InstanceInfo{
+			Location:     "node1",
+			InstanceType: "container",
+			State:        "Running",
+		},
+		Config: map[string]string{
+			"user.foo":          "bar",
+			"cloud-init.vendor": "#cloud-config",
+			"volatile.uuid":     "hidden",
+		},
+		Devices: map[string]map[string]string{
+			"eth0": {"type": "nic", "network": "incusbr0"},
+		},
+	})
+	defer srv.Close()
+
+	g := srv.Client()
+
+	t.Run("Info", func(t *testing.T) {
+		info, err := g.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := incus.InstanceInfo{
+			APIVersion:   "1.0",
+			Location:     "node1",
+			InstanceType: "container",
+			State:        "Running",
+		}
+		if *info != want {
+			t.Fatalf("got %+v, want %+v", *info, want)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		v, err := g.Config("foo")
+		if err != nil || v != "bar" {
+			t.Fatalf("got %q, %v, want bar", v, err)
+		}
+
+		if _, err := g.Config("missing"); !errors.Is(err, guest.ErrConfigKeyNotFound) {
+			t.Fatalf("got error %v, want ErrConfigKeyNotFound", err)
+		}
+	})
+
+	t.Run("ListConfig", func(t *testing.T) {
+		keys, err := g.ListConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"/1.0/config/cloud-init.vendor", "/1.0/config/user.foo"}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("got %v, want %v", keys, want)
+		}
+	})
+
+	t.Run("Devices", func(t *testing.T) {
+		devices, err := g.Devices()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]map[string]string{
+			"eth0": {"type": "nic", "network": "incusbr0"},
+		}
+		if !reflect.DeepEqual(devices, want) {
+			t.Fatalf("got %v, want %v", devices, want)
+		}
+	})
+
+	t.Run("SetState", func(t *testing.T) {
+		if err := g.SetState(context.Background(), "Ready"); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := g.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.State != "Ready" {
+			t.Fatalf("got state %q, want Ready", info.State)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		evc, errc, err := g.Events(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv.SetConfig("user.foo", "baz")
+		srv.AddDevice("disk0", map[string]string{"type": "disk", "path": "/mnt"})
+
+		ev := next(t, evc, errc)
+		if ev.Type != incus.EventTypeConfig || ev.Config.Key != "user.foo" ||
+			ev.Config.OldValue != "bar" || ev.Config.Value != "baz" {
+			t.Fatalf("got %+v, want config event for user.foo", ev)
+		}
+
+		ev = next(t, evc, errc)
+		if ev.Type != incus.EventTypeDevice || ev.Device.Name != "disk0" ||
+			ev.Device.ActionType != incus.DeviceActionAdd {
+			t.Fatalf("got %+v, want device added event for disk0", ev)
+		}
+
+		if v, err := g.Config("foo"); err != nil || v != "baz" {
+			t.Fatalf("Config after event: got %q, %v, want baz", v, err)
+		}
+	})
+}
+
+// next returns the next event, failing the test if the connection
+// ends or nothing arrives within a few seconds.
+func next(t *testing.T, evc <-chan *incus.Event, errc <-chan error) *incus.Event {
+	t.Helper()
+
+	select {
+	case ev := <-evc:
+		return ev
+	case err := <-errc:
+		t.Fatalf("events connection ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build !race
+
+// The race detector adds allocations, so the budget
+// is only checked without it.
+
+package incus_test
+
+import (
+	"testing"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestEventDecodeAllocs(t *testing.T) {
+	for _, f := range decodeFrames {
+		t.Run(f.name, func(t *testing.T) {
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := incus.ParseEvent(f.data); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			if allocs > f.allocs {
+				t.Fatalf("decoding took %v allocations, want at most %v", allocs, f.allocs)
+			}
+		})
+	}
+}
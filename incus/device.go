@@ -0,0 +1,230 @@
+package incus
+
+import (
+	"strconv"
+)
+
+// Known device types.
+const (
+	DeviceTypeDisk       = "disk"
+	DeviceTypeNIC        = "nic"
+	DeviceTypeGPU        = "gpu"
+	DeviceTypeUnixChar   = "unix-char"
+	DeviceTypeUnixBlock  = "unix-block"
+	DeviceTypeUSB        = "usb"
+	DeviceTypeInfiniband = "infiniband"
+	DeviceTypeProxy      = "proxy"
+	DeviceTypeTPM        = "tpm"
+	DeviceTypePCI        = "pci"
+)
+
+// Device is a device attached to the instance.
+type Device interface {
+	// Type returns the device type, such as "disk" or "nic".
+	Type() string
+
+	// Properties returns every property of the device as
+	// returned by the API, including any without a typed field.
+	Properties() map[string]string
+}
+
+// ParseDevice converts the raw properties of a device into one
+// of the typed device structs, based on its type. Devices of an
+// unknown type are returned as a *GenericDevice.
+func ParseDevice(props map[string]string) Device {
+	switch props["type"] {
+	case DeviceTypeDisk:
+		return &DiskDevice{
+			Path:     props["path"],
+			Source:   props["source"],
+			Pool:     props["pool"],
+			Size:     props["size"],
+			ReadOnly: parseBool(props["readonly"]),
+			Required: parseRequired(props["required"]),
+			Raw:      props,
+		}
+	case DeviceTypeNIC:
+		return &NICDevice{
+			Name:     props["name"],
+			NICType:  props["nictype"],
+			Network:  props["network"],
+			Parent:   props["parent"],
+			HWAddr:   props["hwaddr"],
+			MTU:      props["mtu"],
+			VLAN:     props["vlan"],
+			HostName: props["host_name"],
+			Raw:      props,
+		}
+	case DeviceTypeGPU:
+		return &GPUDevice{
+			GPUType:   props["gputype"],
+			ID:        props["id"],
+			PCI:       props["pci"],
+			VendorID:  props["vendorid"],
+			ProductID: props["productid"],
+			UID:       props["uid"],
+			GID:       props["gid"],
+			Mode:      props["mode"],
+			Raw:       props,
+		}
+	case DeviceTypeUnixChar:
+		return &UnixCharDevice{
+			Source:   props["source"],
+			Path:     props["path"],
+			Major:    props["major"],
+			Minor:    props["minor"],
+			UID:      props["uid"],
+			GID:      props["gid"],
+			Mode:     props["mode"],
+			Required: parseRequired(props["required"]),
+			Raw:      props,
+		}
+	case DeviceTypeUnixBlock:
+		return &UnixBlockDevice{
+			Source:   props["source"],
+			Path:     props["path"],
+			Major:    props["major"],
+			Minor:    props["minor"],
+			UID:      props["uid"],
+			GID:      props["gid"],
+			Mode:     props["mode"],
+			Required: parseRequired(props["required"]),
+			Raw:      props,
+		}
+	case DeviceTypeUSB:
+		return &USBDevice{
+			VendorID:  props["vendorid"],
+			ProductID: props["productid"],
+			UID:       props["uid"],
+			GID:       props["gid"],
+			Mode:      props["mode"],
+			Required:  parseBool(props["required"]),
+			Raw:       props,
+		}
+	}
+
+	return &GenericDevice{Raw: props}
+}
+
+// parseBool parses a boolean device property, treating
+// anything unparseable as false.
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
+
+// parseRequired parses the "required" property, which
+// defaults to true when unset.
+func parseRequired(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	return parseBool(s)
+}
+
+// DiskDevice is a disk or filesystem mount.
+type DiskDevice struct {
+	Path     string
+	Source   string
+	Pool     string
+	Size     string
+	ReadOnly bool
+	Required bool
+
+	Raw map[string]string
+}
+
+func (d *DiskDevice) Type() string                  { return DeviceTypeDisk }
+func (d *DiskDevice) Properties() map[string]string { return d.Raw }
+
+// NICDevice is a network interface.
+type NICDevice struct {
+	Name     string
+	NICType  string
+	Network  string
+	Parent   string
+	HWAddr   string
+	MTU      string
+	VLAN     string
+	HostName string
+
+	Raw map[string]string
+}
+
+func (d *NICDevice) Type() string                  { return DeviceTypeNIC }
+func (d *NICDevice) Properties() map[string]string { return d.Raw }
+
+// GPUDevice is a GPU passed through to the instance.
+type GPUDevice struct {
+	GPUType   string
+	ID        string
+	PCI       string
+	VendorID  string
+	ProductID string
+	UID       string
+	GID       string
+	Mode      string
+
+	Raw map[string]string
+}
+
+func (d *GPUDevice) Type() string                  { return DeviceTypeGPU }
+func (d *GPUDevice) Properties() map[string]string { return d.Raw }
+
+// UnixCharDevice is a Unix character device.
+type UnixCharDevice struct {
+	Source   string
+	Path     string
+	Major    string
+	Minor    string
+	UID      string
+	GID      string
+	Mode     string
+	Required bool
+
+	Raw map[string]string
+}
+
+func (d *UnixCharDevice) Type() string                  { return DeviceTypeUnixChar }
+func (d *UnixCharDevice) Properties() map[string]string { return d.Raw }
+
+// UnixBlockDevice is a Unix block device.
+type UnixBlockDevice struct {
+	Source   string
+	Path     string
+	Major    string
+	Minor    string
+	UID      string
+	GID      string
+	Mode     string
+	Required bool
+
+	Raw map[string]string
+}
+
+func (d *UnixBlockDevice) Type() string                  { return DeviceTypeUnixBlock }
+func (d *UnixBlockDevice) Properties() map[string]string { return d.Raw }
+
+// USBDevice is a USB device passed through to the instance.
+type USBDevice struct {
+	VendorID  string
+	ProductID string
+	UID       string
+	GID       string
+	Mode      string
+	Required  bool
+
+	Raw map[string]string
+}
+
+func (d *USBDevice) Type() string                  { return DeviceTypeUSB }
+func (d *USBDevice) Properties() map[string]string { return d.Raw }
+
+// GenericDevice is a device of a type without a typed struct.
+type GenericDevice struct {
+	Raw map[string]string
+}
+
+func (d *GenericDevice) Type() string                  { return d.Raw["type"] }
+func (d *GenericDevice) Properties() map[string]string { return d.Raw }
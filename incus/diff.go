@@ -0,0 +1,92 @@
+package incus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConfigDiff describes the differences between two sets of config.
+type ConfigDiff struct {
+	// Added holds keys only in the new config, with their values.
+	Added map[string]string
+
+	// Removed holds keys only in the old config, with their values.
+	Removed map[string]string
+
+	// Changed holds keys in both whose value differs.
+	Changed map[string]ConfigChange
+}
+
+// ConfigChange is the old and new value of a changed config key.
+type ConfigChange struct {
+	Old string
+	New string
+}
+
+// DiffConfig compares two sets of config, such as those returned
+// by AllConfig at different times.
+func DiffConfig(old, new map[string]string) ConfigDiff {
+	diff := ConfigDiff{
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string]ConfigChange),
+	}
+
+	for k, v := range old {
+		nv, ok := new[k]
+		if !ok {
+			diff.Removed[k] = v
+		} else if nv != v {
+			diff.Changed[k] = ConfigChange{Old: v, New: nv}
+		}
+	}
+
+	for k, v := range new {
+		if _, ok := old[k]; !ok {
+			diff.Added[k] = v
+		}
+	}
+
+	return diff
+}
+
+// Empty reports whether there are no differences.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a summary of the differences, one key per line
+// in key order, marked + if added, - if removed and ~ if changed.
+func (d ConfigDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	lines := make(map[string]string, len(d.Added)+len(d.Removed)+len(d.Changed))
+	for k, v := range d.Added {
+		lines[k] = fmt.Sprintf("+ %s=%q", k, v)
+	}
+	for k, v := range d.Removed {
+		lines[k] = fmt.Sprintf("- %s=%q", k, v)
+	}
+	for k, c := range d.Changed {
+		lines[k] = fmt.Sprintf("~ %s: %q -> %q", k, c.Old, c.New)
+	}
+
+	keys := make([]string, 0, len(lines))
+	for k := range lines {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(lines[k])
+	}
+
+	return b.String()
+}
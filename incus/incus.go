@@ -2,7 +2,11 @@
 package incus
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type EventType string
@@ -10,8 +14,14 @@ type EventType string
 const (
 	EventTypeConfig EventType = "config"
 	EventTypeDevice EventType = "device"
+
+	// EventTypeAll subscribes to every event type. It's never
+	// the type of a received event.
+	EventTypeAll EventType = "all"
 )
 
+// Valid reports whether et is the type of an event sent by
+// the guest API.
 func (et EventType) Valid() bool {
 	if et != EventTypeConfig && et != EventTypeDevice {
 		return false
@@ -20,19 +30,127 @@ func (et EventType) Valid() bool {
 	return true
 }
 
+// ServerInfo describes the guest API.
+type ServerInfo struct {
+	// APIVersions lists the supported API versions as paths,
+	// for example "/1.0".
+	APIVersions []string `json:"api_versions"`
+}
+
+// Supports reports whether the API supports the given version,
+// for example "1.0".
+func (si *ServerInfo) Supports(version string) bool {
+	for _, v := range si.APIVersions {
+		if strings.TrimPrefix(v, "/") == strings.TrimPrefix(version, "/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InstanceState is the state of an instance.
+type InstanceState string
+
+const (
+	StateRunning  InstanceState = "Running"
+	StateStarted  InstanceState = "Started"
+	StateReady    InstanceState = "Ready"
+	StateStopped  InstanceState = "Stopped"
+	StateFrozen   InstanceState = "Frozen"
+	StateStarting InstanceState = "Starting"
+	StateStopping InstanceState = "Stopping"
+	StateError    InstanceState = "Error"
+)
+
+// InstanceType is the type of an instance.
+type InstanceType string
+
+const (
+	InstanceTypeContainer InstanceType = "container"
+	InstanceTypeVM        InstanceType = "virtual-machine"
+)
+
+// ParseInstanceType parses an instance type, ignoring case and
+// surrounding whitespace. "vm" is accepted as "virtual-machine".
+func ParseInstanceType(s string) (InstanceType, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "vm" {
+		return InstanceTypeVM, nil
+	}
+
+	it := InstanceType(s)
+	if !it.Valid() {
+		return "", fmt.Errorf("unknown instance type %q", s)
+	}
+
+	return it, nil
+}
+
+// Valid reports whether it is an instance type known to
+// this package.
+func (it InstanceType) Valid() bool {
+	if it != InstanceTypeContainer && it != InstanceTypeVM {
+		return false
+	}
+
+	return true
+}
+
 type InstanceInfo struct {
-	APIVersion   string `json:"api_version"`
-	Location     string `json:"location"`
+	APIVersion string `json:"api_version"`
+	Location   string `json:"location"`
+
+	// InstanceType is kept as a string so that types added to
+	// Incus later are still reported. Type returns it typed.
 	InstanceType string `json:"instance_type"`
 	State        string `json:"state"`
 }
 
+// InstanceState returns State as an InstanceState.
+func (i InstanceInfo) InstanceState() InstanceState {
+	return InstanceState(i.State)
+}
+
+// Type returns InstanceType as an InstanceType.
+func (i InstanceInfo) Type() InstanceType {
+	return InstanceType(i.InstanceType)
+}
+
+// IsContainer reports whether the instance is a container.
+func (i InstanceInfo) IsContainer() bool {
+	return i.Type() == InstanceTypeContainer
+}
+
+// IsVM reports whether the instance is a virtual machine.
+func (i InstanceInfo) IsVM() bool {
+	return i.Type() == InstanceTypeVM
+}
+
+// String describes the instance, for example
+// "container Running on node1".
+func (i InstanceInfo) String() string {
+	s := i.InstanceType + " " + i.State
+	if i.Location != "" {
+		s += " on " + i.Location
+	}
+
+	return s
+}
+
 type Event struct {
 	Timestamp string    `json:"timestamp"`
 	Type      EventType `json:"type"`
 
+	// Time is Timestamp parsed as RFC 3339.
+	Time time.Time `json:"-"`
+
 	Config ConfigUpdateMetadata
 	Device DeviceUpdateMetadata
+
+	// RawMetadata holds the undecoded metadata of events
+	// with an unrecognised type.
+	RawMetadata json.RawMessage `json:"-"`
 }
 
 type ConfigUpdateMetadata struct {
@@ -41,48 +159,333 @@ type ConfigUpdateMetadata struct {
 	Value    string `json:"value"`
 }
 
+// String describes the change, for example
+// `user.foo: "old" -> "new"`.
+func (m ConfigUpdateMetadata) String() string {
+	return fmt.Sprintf("%s: %q -> %q", m.Key, m.OldValue, m.Value)
+}
+
 type DeviceUpdateMetadata struct {
 	Name   string       `json:"name"`
 	Action string       `json:"action"`
 	Config DeviceConfig `json:"config"`
+
+	// ActionType is Action as a DeviceAction.
+	ActionType DeviceAction `json:"-"`
+}
+
+func (m *DeviceUpdateMetadata) UnmarshalJSON(data []byte) error {
+	return m.decode(data, false)
+}
+
+// String describes the change, for example "eth0 added (nic)".
+func (m DeviceUpdateMetadata) String() string {
+	s := m.Name + " " + m.Action
+	if m.Config.Type != "" {
+		s += " (" + m.Config.Type + ")"
+	}
+
+	return s
+}
+
+func (m DeviceUpdateMetadata) MarshalJSON() ([]byte, error) {
+	type plain DeviceUpdateMetadata
+	if m.Action == "" {
+		m.Action = string(m.ActionType)
+	}
+
+	return json.Marshal(plain(m))
+}
+
+func (m *DeviceUpdateMetadata) decode(data []byte, strict bool) error {
+	type plain DeviceUpdateMetadata
+	if err := decode(data, (*plain)(m), strict); err != nil {
+		return err
+	}
+
+	m.ActionType = DeviceAction(m.Action)
+	return nil
+}
+
+// decode unmarshals data into v, failing on unknown fields if
+// strict is set.
+func decode(data []byte, v any, strict bool) error {
+	if !strict {
+		return json.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v)
 }
 
+// DeviceAction is the change to a device reported by a device event.
+type DeviceAction string
+
+const (
+	DeviceActionAdd    DeviceAction = "added"
+	DeviceActionRemove DeviceAction = "removed"
+	DeviceActionUpdate DeviceAction = "updated"
+)
+
+// Valid reports whether da is an action sent by the guest API.
+func (da DeviceAction) Valid() bool {
+	if da != DeviceActionAdd && da != DeviceActionRemove && da != DeviceActionUpdate {
+		return false
+	}
+
+	return true
+}
+
+// DeviceConfig is the configuration of a device included in
+// device events. Properties without a field are kept in Extra.
 type DeviceConfig struct {
 	Type string `json:"type"`
 	Path string `json:"path"`
+
+	// unix-char, unix-block and disk
+	Source string `json:"source,omitempty"`
+
+	// unix-char and unix-block
+	Major string `json:"major,omitempty"`
+	Minor string `json:"minor,omitempty"`
+	Mode  string `json:"mode,omitempty"`
+	UID   string `json:"uid,omitempty"`
+	GID   string `json:"gid,omitempty"`
+
+	// nic
+	HWAddr string `json:"hwaddr,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	MTU    string `json:"mtu,omitempty"`
+
+	// disk
+	Pool     string `json:"pool,omitempty"`
+	Size     string `json:"size,omitempty"`
+	ReadOnly bool   `json:"readonly,omitempty"`
+
+	Extra map[string]string `json:"-"`
 }
 
-func (e *Event) UnmarshalJSON(data []byte) error {
-	var intermediary map[string]json.RawMessage
-	if err := json.Unmarshal(data, &intermediary); err != nil {
+func (dc *DeviceConfig) UnmarshalJSON(data []byte) error {
+	var props map[string]string
+	if err := json.Unmarshal(data, &props); err != nil {
 		return err
 	}
 
-	// Unmarshal the guaranteed fields
-	if err := json.Unmarshal(intermediary["timestamp"], &e.Timestamp); err != nil {
-		return err
+	for k, v := range props {
+		if field := dc.field(k); field != nil {
+			*field = v
+			continue
+		}
+
+		if k == "readonly" {
+			dc.ReadOnly = parseBool(v)
+			continue
+		}
+
+		if dc.Extra == nil {
+			dc.Extra = make(map[string]string)
+		}
+		dc.Extra[k] = v
+	}
+
+	return nil
+}
+
+func (dc DeviceConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dc.properties())
+}
+
+// field returns the field holding the named property, or nil if
+// it has none.
+func (dc *DeviceConfig) field(name string) *string {
+	switch name {
+	case "type":
+		return &dc.Type
+	case "path":
+		return &dc.Path
+	case "source":
+		return &dc.Source
+	case "major":
+		return &dc.Major
+	case "minor":
+		return &dc.Minor
+	case "mode":
+		return &dc.Mode
+	case "uid":
+		return &dc.UID
+	case "gid":
+		return &dc.GID
+	case "hwaddr":
+		return &dc.HWAddr
+	case "parent":
+		return &dc.Parent
+	case "mtu":
+		return &dc.MTU
+	case "pool":
+		return &dc.Pool
+	case "size":
+		return &dc.Size
+	default:
+		return nil
+	}
+}
+
+// properties returns the device config as the property map
+// returned when listing devices.
+func (dc *DeviceConfig) properties() map[string]string {
+	props := make(map[string]string, len(dc.Extra))
+	for k, v := range dc.Extra {
+		props[k] = v
 	}
-	if err := json.Unmarshal(intermediary["type"], &e.Type); err != nil {
+
+	fields := map[string]string{
+		"type":   dc.Type,
+		"path":   dc.Path,
+		"source": dc.Source,
+		"major":  dc.Major,
+		"minor":  dc.Minor,
+		"mode":   dc.Mode,
+		"uid":    dc.UID,
+		"gid":    dc.GID,
+		"hwaddr": dc.HWAddr,
+		"parent": dc.Parent,
+		"mtu":    dc.MTU,
+		"pool":   dc.Pool,
+		"size":   dc.Size,
+	}
+
+	for k, v := range fields {
+		if v != "" {
+			props[k] = v
+		}
+	}
+
+	if dc.ReadOnly {
+		props["readonly"] = "true"
+	}
+
+	return props
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	ev, err := ParseEvent(data)
+	if err != nil {
 		return err
 	}
 
+	*e = *ev
+	return nil
+}
+
+// String describes the event by its type and metadata, for example
+// `config user.foo: "old" -> "new"` or "device eth0 added (nic)".
+// Events of other types are described by their type alone.
+func (e Event) String() string {
+	switch e.Type {
+	case EventTypeConfig:
+		return "config " + e.Config.String()
+	case EventTypeDevice:
+		return "device " + e.Device.String()
+	default:
+		return string(e.Type)
+	}
+}
+
+// MarshalJSON encodes the event as sent by the events API, with its
+// metadata taken from the field matching its type. If Timestamp is
+// empty, it's formatted from Time.
+func (e Event) MarshalJSON() ([]byte, error) {
+	timestamp := e.Timestamp
+	if timestamp == "" && !e.Time.IsZero() {
+		timestamp = e.Time.Format(time.RFC3339Nano)
+	}
+
+	var metadata any
+	switch e.Type {
+	case EventTypeConfig:
+		metadata = e.Config
+	case EventTypeDevice:
+		metadata = e.Device
+	default:
+		if e.RawMetadata != nil {
+			metadata = e.RawMetadata
+		}
+	}
+
+	return json.Marshal(struct {
+		Timestamp string    `json:"timestamp"`
+		Type      EventType `json:"type"`
+		Metadata  any       `json:"metadata,omitempty"`
+	}{timestamp, e.Type, metadata})
+}
+
+// ParseEvent decodes an event as sent by the events API, for
+// example one read back from a log.
+func ParseEvent(data []byte) (*Event, error) {
+	return parseEvent(data, false)
+}
+
+// ParseEventStrict is like ParseEvent, but fails if the event or
+// its metadata has fields that aren't known, so that changes to
+// the API are noticed.
+func ParseEventStrict(data []byte) (*Event, error) {
+	return parseEvent(data, true)
+}
+
+// wireEvent is an event as sent by the events API. Events from Incus
+// can include a location and project, which are ignored. Pointers
+// are used so that missing fields can be told apart from empty ones.
+type wireEvent struct {
+	Timestamp *string         `json:"timestamp"`
+	Type      *EventType      `json:"type"`
+	Metadata  json.RawMessage `json:"metadata"`
+	Location  json.RawMessage `json:"location"`
+	Project   json.RawMessage `json:"project"`
+}
+
+func parseEvent(data []byte, strict bool) (*Event, error) {
+	var wire wireEvent
+	if err := decode(data, &wire, strict); err != nil {
+		return nil, err
+	}
+
+	if wire.Timestamp == nil {
+		return nil, fmt.Errorf("event has no timestamp")
+	}
+	if wire.Type == nil {
+		return nil, fmt.Errorf("event has no type")
+	}
+
+	e := &Event{
+		Timestamp: *wire.Timestamp,
+		Type:      *wire.Type,
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event timestamp %q: %w", e.Timestamp, err)
+	}
+	e.Time = t
+
 	// Delegate unmarshalling based on event type
 	switch e.Type {
 	case "config":
-		meta, ok := intermediary["metadata"]
-		if !ok {
-			return nil
+		if wire.Metadata != nil {
+			if err := decode(wire.Metadata, &e.Config, strict); err != nil {
+				return nil, err
+			}
 		}
-
-		return json.Unmarshal(meta, &e.Config)
 	case "device":
-		meta, ok := intermediary["metadata"]
-		if !ok {
-			return nil
+		if wire.Metadata != nil {
+			if err := e.Device.decode(wire.Metadata, strict); err != nil {
+				return nil, err
+			}
 		}
-
-		return json.Unmarshal(meta, &e.Device)
+	default:
+		e.RawMetadata = wire.Metadata
 	}
 
-	return nil
+	return e, nil
 }
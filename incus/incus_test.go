@@ -0,0 +1,250 @@
+package incus_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestEventUnknownType(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "object metadata",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"lifecycle","metadata":{"action":"instance-started","source":"/1.0/instances/c1"}}`,
+			want: `{"action":"instance-started","source":"/1.0/instances/c1"}`,
+		},
+		{
+			name: "array metadata",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"logging","metadata":[1,2,3]}`,
+			want: `[1,2,3]`,
+		},
+		{
+			name: "no metadata",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"lifecycle"}`,
+			want: ``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ev incus.Event
+			if err := json.Unmarshal([]byte(tt.data), &ev); err != nil {
+				t.Fatal(err)
+			}
+
+			if string(ev.RawMetadata) != tt.want {
+				t.Fatalf("RawMetadata = %s, want %s", ev.RawMetadata, tt.want)
+			}
+
+			if ev.Config != (incus.ConfigUpdateMetadata{}) || ev.Device.Name != "" {
+				t.Fatalf("typed metadata set for unknown type: %+v", ev)
+			}
+		})
+	}
+}
+
+func TestParseEventRawMetadataIsCopied(t *testing.T) {
+	data := []byte(`{"timestamp":"2024-01-02T03:04:05Z","type":"lifecycle","metadata":{"a":1}}`)
+
+	ev, err := incus.ParseEvent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Event listeners reuse the buffer an event is read into.
+	for i := range data {
+		data[i] = 'x'
+	}
+
+	if string(ev.RawMetadata) != `{"a":1}` {
+		t.Fatalf("RawMetadata = %s after reusing the input", ev.RawMetadata)
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "config",
+			data: `{"timestamp":"2024-01-02T03:04:05.123456789Z","type":"config","metadata":{"key":"user.foo","old_value":"a","value":"b"}}`,
+		},
+		{
+			name: "config removed",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"config","metadata":{"key":"user.foo","old_value":"a","value":""}}`,
+		},
+		{
+			name: "device",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"device","metadata":{"name":"eth0","action":"added","config":{"type":"nic","network":"incusbr0","hwaddr":"00:16:3e:00:00:01","mtu":"1500"}}}`,
+		},
+		{
+			name: "device readonly disk",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"device","metadata":{"name":"data","action":"updated","config":{"type":"disk","path":"/mnt","source":"/srv","readonly":"true"}}}`,
+		},
+		{
+			name: "unknown",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"lifecycle","metadata":{"action":"instance-started","source":"/1.0/instances/c1"}}`,
+		},
+		{
+			name: "unknown without metadata",
+			data: `{"timestamp":"2024-01-02T03:04:05Z","type":"lifecycle"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := incus.ParseEvent([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := json.Marshal(first)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := incus.ParseEvent(data)
+			if err != nil {
+				t.Fatalf("parsing marshalled event %s: %v", data, err)
+			}
+
+			if !reflect.DeepEqual(first, second) {
+				t.Fatalf("event changed by round trip:\n got %+v\nwant %+v", second, first)
+			}
+
+			again, err := json.Marshal(second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(again) != string(data) {
+				t.Fatalf("marshalling isn't stable:\n got %s\nwant %s", again, data)
+			}
+
+			// The input uses the same fields as the events API,
+			// so marshalling should reproduce it.
+			if !jsonEqual(t, data, []byte(tt.data)) {
+				t.Fatalf("marshalled event doesn't match input:\n got %s\nwant %s", data, tt.data)
+			}
+		})
+	}
+}
+
+// jsonEqual reports whether a and b hold the same JSON value.
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatal(err)
+	}
+
+	return reflect.DeepEqual(av, bv)
+}
+
+func TestEventMarshalFallbacks(t *testing.T) {
+	ev := incus.Event{
+		Type: incus.EventTypeDevice,
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Device: incus.DeviceUpdateMetadata{
+			Name:       "eth0",
+			ActionType: incus.DeviceActionRemove,
+			Config:     incus.DeviceConfig{Type: "nic"},
+		},
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := incus.ParseEvent(data)
+	if err != nil {
+		t.Fatalf("parsing marshalled event %s: %v", data, err)
+	}
+
+	if got.Timestamp != "2024-01-02T03:04:05Z" || !got.Time.Equal(ev.Time) {
+		t.Fatalf("got timestamp %q (%v), want it taken from Time", got.Timestamp, got.Time)
+	}
+
+	if got.Device.Action != string(incus.DeviceActionRemove) || got.Device.ActionType != incus.DeviceActionRemove {
+		t.Fatalf("got action %q (%q), want it taken from ActionType", got.Device.Action, got.Device.ActionType)
+	}
+}
+
+func TestInstanceInfoMethods(t *testing.T) {
+	// Calling the methods on unaddressable values requires
+	// value receivers.
+	infos := map[string]incus.InstanceInfo{
+		"container": {InstanceType: "container", State: "Running"},
+		"vm":        {InstanceType: "virtual-machine", State: "Stopped"},
+	}
+
+	if c := infos["container"]; !c.IsContainer() || c.IsVM() || c.Type() != incus.InstanceTypeContainer {
+		t.Fatalf("container reported as %q", c.Type())
+	}
+
+	if !infos["vm"].IsVM() || infos["vm"].IsContainer() || infos["vm"].Type() != incus.InstanceTypeVM {
+		t.Fatalf("vm reported as %q", infos["vm"].Type())
+	}
+
+	if state := infos["vm"].InstanceState(); state != incus.StateStopped {
+		t.Fatalf("got state %q, want %q", state, incus.StateStopped)
+	}
+
+	if s := (incus.InstanceInfo{InstanceType: "container", State: "Running"}).String(); s == "" {
+		t.Fatal("String returned an empty string")
+	}
+}
+
+// decodeFrames are events as sent by the events API, used to
+// measure decoding.
+var decodeFrames = []struct {
+	name string
+	data []byte
+
+	// allocs is the most allocations decoding may take.
+	allocs float64
+}{
+	{
+		name:   "config",
+		data:   []byte(`{"timestamp":"2024-01-02T03:04:05.123456789Z","type":"config","metadata":{"key":"user.foo","old_value":"a","value":"b"},"location":"none","project":"default"}`),
+		allocs: 7,
+	},
+	{
+		name:   "device",
+		data:   []byte(`{"timestamp":"2024-01-02T03:04:05.123456789Z","type":"device","metadata":{"name":"eth0","action":"added","config":{"type":"nic","network":"incusbr0","hwaddr":"00:16:3e:00:00:01"}},"location":"none","project":"default"}`),
+		allocs: 14,
+	},
+	{
+		name:   "unknown",
+		data:   []byte(`{"timestamp":"2024-01-02T03:04:05.123456789Z","type":"lifecycle","metadata":{"action":"instance-started","source":"/1.0/instances/c1"},"location":"none","project":"default"}`),
+		allocs: 7,
+	},
+}
+
+func BenchmarkEventDecode(b *testing.B) {
+	for _, f := range decodeFrames {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.data)))
+
+			for i := 0; i < b.N; i++ {
+				if _, err := incus.ParseEvent(f.data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package incus
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MetaData is the cloud-init meta-data provided to the instance.
+type MetaData struct {
+	InstanceID    string     `yaml:"instance-id"`
+	LocalHostname string     `yaml:"local-hostname"`
+	PublicKeys    PublicKeys `yaml:"public-keys,omitempty"`
+
+	// Extra holds any keys without a field.
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// PublicKeys holds SSH public keys from meta-data, by name.
+//
+// cloud-init accepts either a map of names to keys, a list of keys
+// or a single key. Keys given as a list or single key are named by
+// their index, starting at "0".
+type PublicKeys map[string]string
+
+func (pk *PublicKeys) UnmarshalYAML(node *yaml.Node) error {
+	keys := PublicKeys{}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		keys["0"] = node.Value
+	case yaml.SequenceNode:
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+
+		for i, key := range list {
+			keys[strconv.Itoa(i)] = key
+		}
+	case yaml.MappingNode:
+		var m map[string]string
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+
+		for name, key := range m {
+			keys[name] = key
+		}
+	default:
+		return fmt.Errorf("public-keys must be a map, list or string, got %s", node.Tag)
+	}
+
+	*pk = keys
+	return nil
+}
+
+// AuthorizedKeys returns every key, ordered by name, in the form
+// used by an authorized_keys file. Values holding more than one
+// key on separate lines are split.
+func (pk PublicKeys) AuthorizedKeys() []string {
+	names := make([]string, 0, len(pk))
+	for name := range pk {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		// Keep keys named by index in list order.
+		i, errA := strconv.Atoi(a)
+		j, errB := strconv.Atoi(b)
+		if errA == nil && errB == nil {
+			return cmp.Compare(i, j)
+		}
+		return strings.Compare(a, b)
+	})
+
+	out := []string{}
+	for _, name := range names {
+		for _, line := range strings.Split(pk[name], "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				out = append(out, line)
+			}
+		}
+	}
+
+	return out
+}
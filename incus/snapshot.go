@@ -0,0 +1,83 @@
+package incus
+
+import (
+	"maps"
+	"time"
+)
+
+// Snapshot is the state of an instance as seen through the
+// guest API at a point in time. It isn't safe to call Apply
+// while the snapshot is read from other goroutines.
+type Snapshot struct {
+	Info    InstanceInfo
+	Devices map[string]map[string]string
+	Config  map[string]string
+
+	// CapturedAt is when the snapshot was taken.
+	CapturedAt time.Time
+}
+
+// Apply updates the snapshot with the change described by ev,
+// reporting whether anything changed. Events of other types are
+// ignored.
+//
+// Config events with an empty value set the key to "". Incus sends
+// the same event when a key is removed, so the event alone can't
+// tell the two apart. Callers that need to know should fetch the
+// key again, as the guest package's ConfigStore does.
+//
+// Device events are applied by their action: DeviceActionAdd and
+// DeviceActionUpdate both set the device's config, replacing any
+// existing entry, and DeviceActionRemove deletes it.
+func (s *Snapshot) Apply(ev *Event) bool {
+	switch ev.Type {
+	case EventTypeConfig:
+		return s.applyConfig(ev.Config)
+	case EventTypeDevice:
+		return s.applyDevice(ev.Device)
+	}
+
+	return false
+}
+
+func (s *Snapshot) applyConfig(update ConfigUpdateMetadata) bool {
+	old, ok := s.Config[update.Key]
+	if ok && old == update.Value {
+		return false
+	}
+
+	if s.Config == nil {
+		s.Config = make(map[string]string)
+	}
+	s.Config[update.Key] = update.Value
+
+	return true
+}
+
+func (s *Snapshot) applyDevice(update DeviceUpdateMetadata) bool {
+	old, ok := s.Devices[update.Name]
+
+	switch update.ActionType {
+	case DeviceActionAdd, DeviceActionUpdate:
+		props := update.Config.properties()
+		if ok && maps.Equal(old, props) {
+			return false
+		}
+
+		if s.Devices == nil {
+			s.Devices = make(map[string]map[string]string)
+		}
+		s.Devices[update.Name] = props
+
+		return true
+	case DeviceActionRemove:
+		if !ok {
+			return false
+		}
+
+		delete(s.Devices, update.Name)
+		return true
+	}
+
+	return false
+}
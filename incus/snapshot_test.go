@@ -0,0 +1,46 @@
+package incus_test
+
+import (
+	"testing"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestSnapshotApplyConfig(t *testing.T) {
+	snap := incus.Snapshot{
+		Config: map[string]string{"user.foo": "bar"},
+	}
+
+	configEvent := func(key, old, value string) *incus.Event {
+		return &incus.Event{
+			Type:   incus.EventTypeConfig,
+			Config: incus.ConfigUpdateMetadata{Key: key, OldValue: old, Value: value},
+		}
+	}
+
+	if !snap.Apply(configEvent("user.foo", "bar", "baz")) {
+		t.Fatal("Apply reported no change for a new value")
+	}
+
+	if snap.Apply(configEvent("user.foo", "bar", "baz")) {
+		t.Fatal("Apply reported a change for the same value")
+	}
+
+	// An empty value may mean the key was set empty or removed,
+	// so it's kept rather than deleted.
+	if !snap.Apply(configEvent("user.foo", "baz", "")) {
+		t.Fatal("Apply reported no change for an empty value")
+	}
+
+	if v, ok := snap.Config["user.foo"]; !ok || v != "" {
+		t.Fatalf("got %q, %v, want the key kept with an empty value", v, ok)
+	}
+
+	if !snap.Apply(configEvent("user.new", "", "")) {
+		t.Fatal("Apply reported no change for a new empty key")
+	}
+
+	if _, ok := snap.Config["user.new"]; !ok {
+		t.Fatal("new key with an empty value wasn't added")
+	}
+}
@@ -0,0 +1,123 @@
+package incus
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// UserDataPart is one part of cloud-init user-data.
+type UserDataPart struct {
+	// ContentType is the MIME type of the part, such as
+	// "text/cloud-config" or "text/x-shellscript".
+	ContentType string
+
+	// Filename is the name given to the part, if any.
+	Filename string
+
+	Body string
+}
+
+// userDataPrefixes maps the first line of user-data that isn't a MIME
+// document to the content type cloud-init treats it as.
+var userDataPrefixes = []struct {
+	prefix      string
+	contentType string
+}{
+	{"#cloud-config", "text/cloud-config"},
+	{"#!", "text/x-shellscript"},
+	{"#include", "text/x-include-url"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#part-handler", "text/part-handler"},
+	{"## template: jinja", "text/jinja2"},
+}
+
+// ParseUserData splits cloud-init user-data into its parts. If it's a
+// MIME multipart document, each part is returned, including those of
+// any nested multipart documents. Otherwise it's returned as a single
+// part, with the content type cloud-init would infer from its first line.
+func ParseUserData(data string) ([]UserDataPart, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil || msg.Header.Get("Content-Type") == "" {
+		return []UserDataPart{{
+			ContentType: inferContentType(data),
+			Body:        data,
+		}}, nil
+	}
+
+	return readParts(textproto.MIMEHeader(msg.Header), msg.Body)
+}
+
+// inferContentType returns the content type of single-part user-data.
+func inferContentType(data string) string {
+	for _, p := range userDataPrefixes {
+		if strings.HasPrefix(data, p.prefix) {
+			return p.contentType
+		}
+	}
+
+	return "text/plain"
+}
+
+// readParts reads the MIME entity with the given header and body.
+func readParts(header textproto.MIMEHeader, body io.Reader) ([]UserDataPart, error) {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		content, err := io.ReadAll(decodeTransfer(header, body))
+		if err != nil {
+			return nil, fmt.Errorf("reader error: %w", err)
+		}
+
+		_, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+
+		return []UserDataPart{{
+			ContentType: mediaType,
+			Filename:    dispParams["filename"],
+			Body:        string(content),
+		}}, nil
+	}
+
+	mr := multipart.NewReader(body, params["boundary"])
+
+	parts := []UserDataPart{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reader error: %w", err)
+		}
+
+		nested, err := readParts(p.Header, p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, nested...)
+	}
+
+	return parts, nil
+}
+
+// decodeTransfer decodes a base64 body. Quoted-printable bodies
+// are already decoded by the multipart reader.
+func decodeTransfer(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	return body
+}
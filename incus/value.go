@@ -0,0 +1,135 @@
+package incus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueKind is the kind of data a config value appears to hold.
+type ValueKind int
+
+const (
+	// KindString is plain text, or anything not matching another kind.
+	KindString ValueKind = iota
+
+	// KindEmpty is an empty value.
+	KindEmpty
+
+	// KindBool is "true" or "false", in any case.
+	KindBool
+
+	// KindInt is a base 10 integer.
+	KindInt
+
+	// KindFloat is a finite number that isn't an integer.
+	KindFloat
+
+	// KindJSON is a JSON object or array.
+	KindJSON
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindEmpty:
+		return "empty"
+	case KindBool:
+		return "bool"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("ValueKind(%d)", int(k))
+	}
+}
+
+// ConfigValue is a config value along with the kind of data it
+// appears to hold. The kind is a guess made by trying to parse the
+// value, so a string that happens to look like a number is reported
+// as one.
+type ConfigValue struct {
+	Raw  string
+	Kind ValueKind
+}
+
+// ParseConfigValue detects the kind of a raw config value.
+func ParseConfigValue(raw string) ConfigValue {
+	return ConfigValue{Raw: raw, Kind: detectKind(raw)}
+}
+
+func detectKind(raw string) ValueKind {
+	s := strings.TrimSpace(raw)
+
+	switch {
+	case s == "":
+		return KindEmpty
+	case strings.EqualFold(s, "true") || strings.EqualFold(s, "false"):
+		return KindBool
+	}
+
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return KindInt
+	}
+
+	// Words such as "inf" and "NaN" parse as floats, but are
+	// more likely to be meant as text.
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return KindFloat
+	}
+
+	if (s[0] == '{' || s[0] == '[') && json.Valid([]byte(s)) {
+		return KindJSON
+	}
+
+	return KindString
+}
+
+func (v ConfigValue) String() string {
+	return v.Raw
+}
+
+// AsInt parses the value as a base 10 integer.
+func (v ConfigValue) AsInt() (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(v.Raw), 10, 64)
+}
+
+// AsFloat parses the value as a floating point number.
+func (v ConfigValue) AsFloat() (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(v.Raw), 64)
+}
+
+// AsBool parses the value as a bool. Accepted values are those
+// understood by strconv.ParseBool.
+func (v ConfigValue) AsBool() (bool, error) {
+	return strconv.ParseBool(strings.TrimSpace(v.Raw))
+}
+
+// AsJSON unmarshals the value into target.
+func (v ConfigValue) AsJSON(target any) error {
+	return json.Unmarshal([]byte(v.Raw), target)
+}
+
+// ConfigEntry is a config value along with details of the response
+// it was read from.
+type ConfigEntry struct {
+	// Value is the value exactly as received.
+	Value []byte
+
+	// ETag identifies this version of the value, for use with
+	// ConfigIfChanged.
+	ETag string
+
+	// ContentType is the Content-Type of the response.
+	ContentType string
+
+	// Found is false if the key isn't set, in which case
+	// the other fields are empty.
+	Found bool
+}
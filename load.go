@@ -0,0 +1,102 @@
+package guest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// LoadConfig populates the fields of the struct pointed to by target
+// from config, using struct tags to name the key for each field:
+//
+//	type Settings struct {
+//		Workers int           `incus:"user.worker_count,required"`
+//		Debug   bool          `incus:"debug"`
+//		Timeout time.Duration `incus:"user.timeout"`
+//	}
+//
+// Keys are prefixed in the same way as Config. Fields may be strings,
+// bools, ints or time.Durations, and values are parsed as by ConfigInt64,
+// ConfigBool and time.ParseDuration. Fields whose key isn't set are left
+// unchanged, unless the tag includes the required option, in which case
+// an error wrapping ErrConfigKeyNotFound is returned.
+func LoadConfig(g *GuestClient, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("target must be a non-nil pointer to a struct, got %T", target)
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		tag, ok := field.Tag.Lookup("incus")
+		if !ok || tag == "" || tag == "-" {
+			continue
+		}
+
+		key, opts, _ := strings.Cut(tag, ",")
+		required := opts == "required"
+
+		if !field.IsExported() {
+			return fmt.Errorf("field %s is tagged but not exported", field.Name)
+		}
+
+		err := g.loadField(v.Field(i), key)
+		if errors.Is(err, ErrConfigKeyNotFound) && !required {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// loadField sets f to the value of the config key, parsed
+// according to the type of f.
+func (g *GuestClient) loadField(f reflect.Value, key string) error {
+	switch {
+	case f.Type() == durationType:
+		value, err := g.Config(key)
+		if err != nil {
+			return err
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("config key %s is not a valid duration: %w", g.configKey(key), err)
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		value, err := g.Config(key)
+		if err != nil {
+			return err
+		}
+		f.SetString(value)
+	case f.Kind() == reflect.Bool:
+		b, err := g.ConfigBool(key)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case f.CanInt():
+		i, err := g.ConfigInt64(key)
+		if err != nil {
+			return err
+		}
+
+		if f.OverflowInt(i) {
+			return fmt.Errorf("config key %s is out of range for %s", g.configKey(key), f.Type())
+		}
+		f.SetInt(i)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// ConnState is the state of an EventManager's connection.
+type ConnState int
+
+const (
+	// ConnConnecting means a connection is being opened.
+	ConnConnecting ConnState = iota
+
+	// ConnConnected means events are being received.
+	ConnConnected
+
+	// ConnDisconnected means the connection was lost and the
+	// manager is waiting to reconnect, or that it has stopped.
+	ConnDisconnected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnecting:
+		return "connecting"
+	case ConnConnected:
+		return "connected"
+	case ConnDisconnected:
+		return "disconnected"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// EventManager keeps an events connection open in the background,
+// reconnecting whenever it's lost for as long as the manager runs,
+// and reports the health of the connection. It's safe for
+// concurrent use.
+type EventManager struct {
+	g      *GuestClient
+	events []incus.EventType
+	evc    chan *incus.Event
+
+	mu          sync.Mutex
+	state       ConnState
+	connectedAt time.Time
+	connects    uint64
+	err         error
+}
+
+// NewEventManager returns a manager for events received by g. Event
+// types are handled as in ListenForEvents. Call Start to connect.
+func NewEventManager(g *GuestClient, events ...incus.EventType) *EventManager {
+	return &EventManager{
+		g:      g,
+		events: events,
+		evc:    make(chan *incus.Event),
+	}
+}
+
+// Start connects to the events API in the background and returns
+// immediately. Received events are sent on the channel returned by
+// Events until ctx is done or the client is closed. A failure to
+// connect is retried with the client's reconnect backoff, regardless
+// of whether WithReconnect is set. Start must only be called once.
+//
+// An error is returned if any of the event types are invalid.
+func (m *EventManager) Start(ctx context.Context) error {
+	if _, err := subscriptionTypes(m.events); err != nil {
+		return err
+	}
+
+	go m.run(ctx)
+
+	return nil
+}
+
+func (m *EventManager) run(ctx context.Context) {
+	defer close(m.evc)
+	defer m.setState(ConnDisconnected)
+
+	hooks := listenHooks{
+		reconnected:  m.connected,
+		disconnected: m.disconnected,
+	}
+
+	for attempt := 1; ; {
+		m.setState(ConnConnecting)
+
+		conn, err := m.g.dialEvents(ctx, m.events)
+		if err == nil {
+			attempt = 1
+			m.connected()
+
+			err = m.g.dispatch(ctx, conn, m.events, hooks, func(ev *incus.Event) error {
+				select {
+				case m.evc <- ev:
+				case <-ctx.Done():
+				}
+				return nil
+			})
+		} else {
+			m.disconnected(err)
+		}
+
+		if ctx.Err() != nil || errors.Is(err, ErrClientClosed) {
+			return
+		}
+
+		delay := m.g.backoff(attempt)
+		attempt++
+		m.g.logger.Debug("event manager reconnecting", "error", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (m *EventManager) setState(state ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = state
+}
+
+// connected records that a connection was opened.
+func (m *EventManager) connected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = ConnConnected
+	m.connectedAt = time.Now()
+	m.connects++
+}
+
+// disconnected records that the connection was lost, or
+// couldn't be opened.
+func (m *EventManager) disconnected(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = ConnDisconnected
+	m.connectedAt = time.Time{}
+	if err != nil {
+		m.err = err
+	}
+}
+
+// Events returns the channel that received events are sent on. It's
+// closed once the manager stops.
+func (m *EventManager) Events() <-chan *incus.Event {
+	return m.evc
+}
+
+// State returns the current state of the connection.
+func (m *EventManager) State() ConnState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// Reconnects returns the number of times the connection has been
+// reopened after the first connection.
+func (m *EventManager) Reconnects() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.connects == 0 {
+		return 0
+	}
+
+	return m.connects - 1
+}
+
+// Uptime returns how long the current connection has been open, or
+// zero if not connected.
+func (m *EventManager) Uptime() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state != ConnConnected {
+		return 0
+	}
+
+	return time.Since(m.connectedAt)
+}
+
+// Err returns the error that most recently closed the connection
+// or stopped it being opened, if any.
+func (m *EventManager) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
@@ -0,0 +1,43 @@
+package guest
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// Metrics receives measurements from the client. It's implemented by
+// adapters for metrics libraries, such as one updating Prometheus
+// counters and histograms, so that the client doesn't depend on them
+// directly. Methods may be called concurrently.
+type Metrics interface {
+	// RequestDone is called after each request with its method,
+	// route, status code and duration. The route is the request
+	// path, with any config key replaced by "{key}" to keep the
+	// number of distinct values small. The status code is zero if
+	// the request failed, in which case err is set.
+	RequestDone(method, route string, status int, duration time.Duration, err error)
+
+	// EventReceived is called for each event received by an
+	// event listener, before filtering.
+	EventReceived(eventType incus.EventType)
+
+	// Reconnected is called each time an event listener
+	// reconnects to the events API.
+	Reconnected()
+
+	// EventDropped is called each time an event is discarded
+	// because the event buffer is full.
+	EventDropped()
+}
+
+// route returns the request path with any config key replaced
+// by a placeholder.
+func route(path string) string {
+	if strings.HasPrefix(path, "/1.0/config/") {
+		return "/1.0/config/{key}"
+	}
+
+	return path
+}
@@ -0,0 +1,414 @@
+package guest
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// Option configures a GuestClient.
+type Option func(*GuestClient)
+
+// WithSocketPath sets the path of the guest API socket. Defaults
+// to SocketPath.
+func WithSocketPath(path string) Option {
+	return func(g *GuestClient) {
+		g.socketPath = path
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests.
+//
+// If the client has no transport, or an *http.Transport without
+// a DialContext, it's given one that dials the guest API socket.
+// Any other transport is used untouched and is expected to reach
+// the guest API itself. The provided client is never modified.
+func WithHTTPClient(c *http.Client) Option {
+	return func(g *GuestClient) {
+		g.c = c
+	}
+}
+
+// Dialer opens a connection to the guest API.
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// WithDialer sets a function used to connect to the guest API in place
+// of dialing the socket, such as one connecting to a proxy over TCP.
+// The socket path and WithLXDCompat are then ignored. Abstract unix
+// sockets don't need a dialer, as they can be passed to WithSocketPath
+// with a leading "@".
+func WithDialer(dial Dialer) Option {
+	return func(g *GuestClient) {
+		g.dialer = dial
+	}
+}
+
+// WithTimeout sets a time limit for requests made by the client.
+// For ListenForEvents, it only applies to the initial dial.
+//
+// Individual calls can be bounded further by passing a context
+// with a deadline to the *Context variant of a method.
+func WithTimeout(d time.Duration) Option {
+	return func(g *GuestClient) {
+		g.timeout = d
+	}
+}
+
+// WithDialTimeout sets a time limit for connecting to the socket,
+// separate from the overall request time limit set by WithTimeout.
+// It doesn't apply if WithHTTPClient provides a transport that
+// dials the socket itself.
+func WithDialTimeout(d time.Duration) Option {
+	return func(g *GuestClient) {
+		g.dialTimeout = d
+	}
+}
+
+// WithReconnect makes the event listeners transparently redial the
+// events API if the connection fails, making up to maxAttempts
+// consecutive attempts before giving up. A maxAttempts of zero or
+// less retries indefinitely.
+//
+// The delay between attempts starts at backoff and doubles after
+// each failure up to a limit, with jitter applied. A backoff shorter
+// than 10ms, including zero or less, is raised to 10ms.
+func WithReconnect(maxAttempts int, backoff time.Duration) Option {
+	return func(g *GuestClient) {
+		g.reconnect = true
+		g.maxReconnects = maxAttempts
+		g.reconnectBackoff = max(backoff, minReconnectBackoff)
+	}
+}
+
+// WithMaxReconnectAttempts makes the event listeners reconnect if the
+// connection fails, making up to n consecutive attempts. Zero means
+// unlimited. Once the attempts are exhausted, the listener returns an
+// error wrapping ErrReconnectFailed.
+//
+// Unless set with WithReconnect, the delay between attempts starts
+// at one second.
+func WithMaxReconnectAttempts(n int) Option {
+	return func(g *GuestClient) {
+		g.reconnect = true
+		g.maxReconnects = n
+	}
+}
+
+// WithOnReconnect sets a function called by the event listeners
+// each time they reconnect to the events API. Events may have been
+// missed while disconnected, so this is a good place to re-sync.
+func WithOnReconnect(fn func()) Option {
+	return func(g *GuestClient) {
+		g.onReconnect = fn
+	}
+}
+
+// WithConcurrentCallbacks makes ListenForEvents run each callback in
+// its own goroutine, rather than one at a time in the order events
+// are received.
+func WithConcurrentCallbacks() Option {
+	return func(g *GuestClient) {
+		g.concurrentCallbacks = true
+	}
+}
+
+// WithEventBuffer places a buffer holding up to n events between the
+// connection and the event handler, so that a slow handler doesn't
+// hold up reading. What happens when the buffer fills is decided by
+// the policy set with WithBufferPolicy.
+func WithEventBuffer(n int) Option {
+	return func(g *GuestClient) {
+		g.eventBuffer = n
+	}
+}
+
+// WithBufferPolicy sets what happens when the event buffer is full.
+// Defaults to BufferBlock.
+func WithBufferPolicy(policy BufferPolicy) Option {
+	return func(g *GuestClient) {
+		g.bufferPolicy = policy
+	}
+}
+
+// WithConfigKeyFilter drops config events for keys that don't start
+// with one of the given prefixes before they reach the event handler.
+// Other events are unaffected. As with WithEventFilter, this doesn't
+// apply to the client's own listeners, so WaitForConfig still sees
+// every key.
+func WithConfigKeyFilter(prefixes ...string) Option {
+	return WithEventFilter(func(ev *incus.Event) bool {
+		if ev.Type != incus.EventTypeConfig {
+			return true
+		}
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(ev.Config.Key, prefix) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// WithDeviceNameFilter drops device events for devices not named in
+// names before they reach the event handler. Other events are
+// unaffected. The guest API can only filter by event type, so this
+// is done by the client after each event is received. As with
+// WithEventFilter, this doesn't apply to the client's own listeners,
+// so an EventDispatcher's device handlers still see every device.
+func WithDeviceNameFilter(names ...string) Option {
+	return WithEventFilter(func(ev *incus.Event) bool {
+		if ev.Type != incus.EventTypeDevice {
+			return true
+		}
+
+		return slices.Contains(names, ev.Device.Name)
+	})
+}
+
+// WithEventFilter drops events for which keep returns false before
+// they reach the event handler. If used more than once, an event must
+// be kept by every filter.
+//
+// Filters apply to ListenForEvents, HandleEvents and Events. The
+// listeners run by the client itself, such as those behind
+// WaitForConfig, WatchConfig, ConfigStore, EventDispatcher and
+// EventManager, receive every event.
+func WithEventFilter(keep func(*incus.Event) bool) Option {
+	return func(g *GuestClient) {
+		if keep != nil {
+			g.eventFilters = append(g.eventFilters, keep)
+		}
+	}
+}
+
+// WithPingInterval makes the event listeners ping the events API
+// every d to keep the connection alive. If a ping isn't answered
+// within d, the connection is treated as failed, and redialed if
+// WithReconnect is used.
+//
+// Replies can't be read while the listener is held up by a slow
+// handler, so pings that go unanswered during that time aren't
+// treated as failures.
+func WithPingInterval(d time.Duration) Option {
+	return func(g *GuestClient) {
+		g.pingInterval = d
+	}
+}
+
+// WithEventDedup makes the event listeners discard a config event
+// identical to the previous one, with the same key, old value and
+// value, if received within window of it. Device events are never
+// discarded. SuppressedEvents reports how many have been discarded.
+func WithEventDedup(window time.Duration) Option {
+	return func(g *GuestClient) {
+		g.dedupWindow = window
+	}
+}
+
+// WithSkipBadEvents makes the event listeners skip events that can't
+// be decoded, rather than returning an error and closing the connection.
+// If onErr is non-nil, it's called with each skipped message and the
+// decoding error.
+func WithSkipBadEvents(onErr func(raw []byte, err error)) Option {
+	return func(g *GuestClient) {
+		g.skipBadEvents = true
+		g.onBadEvent = onErr
+	}
+}
+
+// WithLogger sets a logger the client writes debug information to,
+// such as each request made and any reconnection attempts. By
+// default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(g *GuestClient) {
+		if logger != nil {
+			g.logger = logger
+		}
+	}
+}
+
+// discardHandler is a slog.Handler that discards everything.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// WithDefaultConfigPrefix sets the prefix added to config keys that
+// aren't already in the user.* or cloud-init.* namespaces. Defaults
+// to "user.". An empty prefix disables prefixing.
+func WithDefaultConfigPrefix(prefix string) Option {
+	return func(g *GuestClient) {
+		g.configPrefix = prefix
+	}
+}
+
+// WithMaxResponseBytes limits the size of response bodies read by
+// the client, guarding against unbounded payloads. Defaults to
+// DefaultMaxResponseBytes. Zero or less removes the limit.
+func WithMaxResponseBytes(n int64) Option {
+	return func(g *GuestClient) {
+		g.maxResponseBytes = n
+	}
+}
+
+// Middleware wraps the transport used to make requests, for example
+// to add headers, record metrics or log requests.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// WithMiddleware wraps the client's transport with each of mw, with
+// the first being outermost. The transport that dials the guest API
+// is always innermost.
+//
+// Middleware also sees the request that opens the events connection,
+// and must leave its response body untouched for that to work.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(g *GuestClient) {
+		g.middleware = append(g.middleware, mw...)
+	}
+}
+
+// WithRetry makes the client retry GET and HEAD requests that receive
+// a 503 or 429 response, up to maxAttempts attempts in total. The delay
+// between attempts is taken from the Retry-After header if present,
+// otherwise it starts small and doubles each time. Other responses
+// are returned immediately.
+func WithRetry(maxAttempts int) Option {
+	return func(g *GuestClient) {
+		g.retryAttempts = maxAttempts
+	}
+}
+
+// WithCircuitBreaker stops the client contacting the socket after
+// threshold consecutive requests fail to reach it. Requests then fail
+// immediately with ErrCircuitOpen until cooldown has elapsed, after
+// which a single request is let through to probe the socket. If it
+// succeeds, requests resume as normal, otherwise the circuit stays
+// open for another cooldown.
+//
+// Only failures to reach the socket are counted, not error responses.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(g *GuestClient) {
+		if threshold <= 0 {
+			g.breaker = nil
+			return
+		}
+
+		g.breaker = &breaker{
+			threshold: threshold,
+			cooldown:  cooldown,
+		}
+	}
+}
+
+// WithCache makes the client cache the results of Info, Devices and
+// ListConfig for ttl, returning copies of the cached values until they
+// expire. If an events connection is open, config and device events
+// discard the matching entries early. Use InvalidateCache to discard
+// everything.
+func WithCache(ttl time.Duration) Option {
+	return func(g *GuestClient) {
+		if ttl <= 0 {
+			g.cache = nil
+			return
+		}
+
+		g.cache = newCache(ttl)
+	}
+}
+
+// WithStrictDecoding makes the client fail to decode JSON responses
+// and events containing fields it doesn't know about, rather than
+// ignoring them, so that changes to the API are noticed. Device
+// config is exempt, as unknown properties are kept in Extra.
+func WithStrictDecoding() Option {
+	return func(g *GuestClient) {
+		g.strictDecoding = true
+	}
+}
+
+// WithEmptyAsNotFound makes the client treat a config key that is
+// set but empty as though it isn't set, for deployments that clear
+// keys by emptying them. Config and the methods built on it return
+// ErrConfigKeyNotFound, HasConfig reports false, and AllConfig and
+// ConfigStore leave the key out. ConfigIfChanged and ConfigReader
+// still return empty values as they are.
+func WithEmptyAsNotFound() Option {
+	return func(g *GuestClient) {
+		g.emptyAsNotFound = true
+	}
+}
+
+// WithTracer makes the client record a span with tracer for each
+// request, with the method, path and status code as attributes, and
+// a span lasting as long as each event listener, with an event for
+// each reconnection. Without a tracer, nothing is recorded.
+func WithTracer(tracer Tracer) Option {
+	return func(g *GuestClient) {
+		g.tracer = tracer
+	}
+}
+
+// WithMetrics makes the client report requests, received events,
+// reconnections and dropped events to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(g *GuestClient) {
+		g.metrics = metrics
+	}
+}
+
+// WithLXDCompat makes the client fall back to LXDSocketPath if the
+// configured socket can't be reached, for use inside LXD instances.
+// The Incus socket is always tried first. IsInsideInstance can be
+// passed LXDSocketPath to detect the LXD socket.
+//
+// The LXD guest API (devlxd) shares its endpoints and formats with
+// Incus, so no other differences are handled.
+func WithLXDCompat() Option {
+	return func(g *GuestClient) {
+		g.lxdCompat = true
+	}
+}
+
+// WithOnConnect sets a function called by the event listeners each
+// time a connection to the events API is opened, including reconnects.
+func WithOnConnect(fn func()) Option {
+	return func(g *GuestClient) {
+		g.onConnect = fn
+	}
+}
+
+// WithOnDisconnect sets a function called by the event listeners each
+// time a connection to the events API closes, with the error that
+// caused it, or nil if the listener was stopped.
+func WithOnDisconnect(fn func(error)) Option {
+	return func(g *GuestClient) {
+		g.onDisconnect = fn
+	}
+}
+
+// WithOnSubscribe sets a function called each time the events API
+// is connected to, with the event types subscribed to. If every
+// type was requested, each is listed.
+func WithOnSubscribe(fn func(types []incus.EventType)) Option {
+	return func(g *GuestClient) {
+		g.onSubscribe = fn
+	}
+}
+
+// WithPropagatePanics stops the event listeners from recovering
+// panics in event callbacks, so they crash the program as usual.
+func WithPropagatePanics() Option {
+	return func(g *GuestClient) {
+		g.propagatePanics = true
+	}
+}
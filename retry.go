@@ -0,0 +1,57 @@
+package guest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// retryBackoff is the delay before the first retry when the
+	// response doesn't say how long to wait. It doubles each time.
+	retryBackoff = 500 * time.Millisecond
+
+	// maxRetryDelay caps the delay between retries.
+	maxRetryDelay = 30 * time.Second
+)
+
+// shouldRetry reports whether req can be retried after receiving resp.
+// Only idempotent requests are retried, and only when the guest API
+// is temporarily unavailable.
+func shouldRetry(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date, into a delay relative
+// to now. It returns false if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		// Clamp before converting, as a large value would
+		// overflow time.Duration.
+		seconds = min(seconds, int(maxRetryDelay/time.Second))
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	// A date in the past means there's no need to wait.
+	return max(t.Sub(now), 0), true
+}
@@ -0,0 +1,66 @@
+package guest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "seconds", value: "5", want: 5 * time.Second, wantOK: true},
+		{name: "zero", value: "0", want: 0, wantOK: true},
+		{name: "padded", value: " 2 ", want: 2 * time.Second, wantOK: true},
+		{name: "past date", value: "Tue, 02 Jan 2024 03:03:05 GMT", want: 0, wantOK: true},
+		{name: "future date", value: "Tue, 02 Jan 2024 03:04:15 GMT", want: 10 * time.Second, wantOK: true},
+		{name: "huge", value: "9999999999999", want: maxRetryDelay, wantOK: true},
+		{name: "negative", value: "-1", wantOK: false},
+		{name: "garbage", value: "soon", wantOK: false},
+		{name: "fractional", value: "1.5", wantOK: false},
+		{name: "empty", value: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		method string
+		status int
+		want   bool
+	}{
+		{http.MethodGet, http.StatusServiceUnavailable, true},
+		{http.MethodGet, http.StatusTooManyRequests, true},
+		{http.MethodHead, http.StatusServiceUnavailable, true},
+		{http.MethodGet, http.StatusInternalServerError, false},
+		{http.MethodGet, http.StatusOK, false},
+		{http.MethodPost, http.StatusServiceUnavailable, false},
+		{http.MethodPut, http.StatusTooManyRequests, false},
+		{http.MethodPatch, http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://unix/1.0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := shouldRetry(req, &http.Response{StatusCode: tt.status}); got != tt.want {
+			t.Errorf("shouldRetry(%s, %d) = %v, want %v", tt.method, tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package guest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// Snapshot fetches the instance info, devices and all config
+// concurrently, returning them together. If any fetch fails, the
+// others are cancelled and the error is returned.
+func (g *GuestClient) Snapshot(ctx context.Context) (*incus.Snapshot, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	snap := &incus.Snapshot{
+		CapturedAt: time.Now(),
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	fetch := func(fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	fetch(func() error {
+		info, err := g.InfoContext(ctx)
+		if err != nil {
+			return err
+		}
+		snap.Info = *info
+		return nil
+	})
+
+	fetch(func() error {
+		devices, err := g.DevicesContext(ctx)
+		if err != nil {
+			return err
+		}
+		snap.Devices = devices
+		return nil
+	})
+
+	fetch(func() error {
+		config, err := g.AllConfigContext(ctx)
+		if err != nil {
+			return err
+		}
+		snap.Config = config
+		return nil
+	})
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return snap, nil
+}
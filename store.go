@@ -0,0 +1,215 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// ConfigStore holds a copy of the instance config, kept current
+// by applying config events as they're received. It's safe for
+// concurrent use.
+type ConfigStore struct {
+	g *GuestClient
+
+	mu       sync.RWMutex
+	values   map[string]string
+	err      error
+	onChange []func(incus.ConfigUpdateMetadata)
+}
+
+// NewConfigStore returns an empty ConfigStore for g. Call Start
+// to load the config and begin applying updates.
+func NewConfigStore(g *GuestClient) *ConfigStore {
+	return &ConfigStore{
+		g:      g,
+		values: make(map[string]string),
+	}
+}
+
+// Start loads all config into the store, then applies config events
+// in the background until ctx is done or the events connection fails.
+// An error is returned if the config can't be loaded.
+//
+// If the client is configured with WithReconnect, changes may be missed
+// while disconnected, so after reconnecting all config is loaded again
+// and any differences are applied as though events had been received.
+func (s *ConfigStore) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Resyncs are run by the goroutine applying events, so that an
+	// event from before the connection was lost can't be applied
+	// over newer values, and OnChange is only ever called from one
+	// goroutine. The reconnected hook is called before anything is
+	// read from the new connection, and waits for the resync to
+	// finish.
+	resync := make(chan chan struct{})
+
+	// Subscribe before loading so no change is missed
+	// between the two.
+	evc, errc, err := s.g.events(ctx, listenHooks{
+		reconnected: func() {
+			done := make(chan struct{})
+			select {
+			case resync <- done:
+				<-done
+			case <-ctx.Done():
+			}
+		},
+	}, incus.EventTypeConfig)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	values, err := s.g.AllConfigContext(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	s.mu.Lock()
+	s.values = values
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+
+	loop:
+		for {
+			select {
+			case ev, ok := <-evc:
+				if !ok {
+					break loop
+				}
+				s.apply(ctx, ev.Config)
+			case done := <-resync:
+				s.resync(ctx)
+				close(done)
+			}
+		}
+
+		s.mu.Lock()
+		s.err = <-errc
+		s.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// apply updates the store with a config change.
+func (s *ConfigStore) apply(ctx context.Context, update incus.ConfigUpdateMetadata) {
+	value := update.Value
+
+	// Unsetting a key also produces an empty value, so
+	// check whether it's still present.
+	if value == "" {
+		var err error
+		value, err = s.g.configValue(ctx, update.Key)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			s.mu.Lock()
+			delete(s.values, update.Key)
+			s.mu.Unlock()
+
+			s.changed(update)
+			return
+		} else if err != nil {
+			s.g.logger.Debug("failed to check config key", "key", update.Key, "error", err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.values[update.Key] = value
+	s.mu.Unlock()
+
+	s.changed(update)
+}
+
+// resync loads all config again, applying any differences from
+// the store and reporting each as a change.
+func (s *ConfigStore) resync(ctx context.Context) {
+	values, err := s.g.AllConfigContext(ctx)
+	if err != nil {
+		s.g.logger.Debug("failed to resync config store", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	diff := incus.DiffConfig(s.values, values)
+	s.values = values
+	s.mu.Unlock()
+
+	updates := []incus.ConfigUpdateMetadata{}
+	for key, value := range diff.Added {
+		updates = append(updates, incus.ConfigUpdateMetadata{Key: key, Value: value})
+	}
+	for key, c := range diff.Changed {
+		updates = append(updates, incus.ConfigUpdateMetadata{Key: key, OldValue: c.Old, Value: c.New})
+	}
+	for key, old := range diff.Removed {
+		updates = append(updates, incus.ConfigUpdateMetadata{Key: key, OldValue: old})
+	}
+
+	slices.SortFunc(updates, func(a, b incus.ConfigUpdateMetadata) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	for _, update := range updates {
+		s.changed(update)
+	}
+}
+
+// OnChange registers fn to be called with each change applied to
+// the store, including those found when resyncing after a reconnect.
+// It's called from the goroutine applying changes, so it shouldn't
+// block for long.
+func (s *ConfigStore) OnChange(fn func(incus.ConfigUpdateMetadata)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onChange = append(s.onChange, fn)
+}
+
+// changed calls the OnChange functions with update.
+func (s *ConfigStore) changed(update incus.ConfigUpdateMetadata) {
+	s.mu.RLock()
+	fns := s.onChange
+	s.mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(update)
+	}
+}
+
+// Get returns the value of the specified config key, and whether
+// it's set. Keys are prefixed in the same way as Config.
+func (s *ConfigStore) Get(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.values[s.g.configKey(key)]
+	return value, ok
+}
+
+// Snapshot returns a copy of all config in the store.
+func (s *ConfigStore) Snapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return maps.Clone(s.values)
+}
+
+// Err returns the error that stopped the store from receiving
+// updates, if any.
+func (s *ConfigStore) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.err
+}
@@ -0,0 +1,84 @@
+package guest_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	guest "github.com/shellhazard/incus-guestapi"
+	"github.com/shellhazard/incus-guestapi/guesttest"
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+func TestConfigStoreResync(t *testing.T) {
+	srv := newServer(t, guesttest.Options{
+		Config: map[string]string{"user.foo": "0", "user.bar": "0"},
+	})
+
+	onConnect, connected := notifyConnect()
+	g := guest.NewClient(
+		guest.WithSocketPath(srv.SocketPath),
+		guest.WithReconnect(10, 20*time.Millisecond),
+		onConnect,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := guest.NewConfigStore(g)
+
+	var inFlight, overlapped atomic.Int32
+	changes := make(chan incus.ConfigUpdateMetadata, 16)
+	store.OnChange(func(update incus.ConfigUpdateMetadata) {
+		if inFlight.Add(1) > 1 {
+			overlapped.Add(1)
+		}
+		defer inFlight.Add(-1)
+
+		// Hold up applying the event received before the drop
+		// until the client has reconnected.
+		if update.Key == "user.foo" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		changes <- update
+	})
+
+	if err := store.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	await(t, "connection", connected)
+
+	srv.SetConfig("user.foo", "1")
+	srv.DropConnections()
+
+	// Changed while disconnected, so only found by resyncing.
+	srv.SetConfig("user.bar", "1")
+
+	await(t, "reconnection", connected)
+
+	if got := await(t, "change to user.foo", changes); got.Key != "user.foo" || got.Value != "1" {
+		t.Fatalf("got change %+v, want user.foo set to 1", got)
+	}
+
+	if got := await(t, "change to user.bar", changes); got.Key != "user.bar" || got.Value != "1" {
+		t.Fatalf("got change %+v, want user.bar set to 1", got)
+	}
+
+	if n := overlapped.Load(); n != 0 {
+		t.Fatalf("OnChange was called concurrently %d times", n)
+	}
+
+	for _, key := range []string{"foo", "bar"} {
+		if got, ok := store.Get(key); !ok || got != "1" {
+			t.Fatalf("Get(%q) = %q, %v, want 1", key, got, ok)
+		}
+	}
+
+	// Changes after reconnecting are still applied.
+	srv.SetConfig("user.foo", "2")
+
+	if got := await(t, "change after reconnecting", changes); got.Key != "user.foo" || got.Value != "2" {
+		t.Fatalf("got change %+v, want user.foo set to 2", got)
+	}
+}
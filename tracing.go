@@ -0,0 +1,55 @@
+package guest
+
+import "context"
+
+// Tracer starts spans for requests made by the client and for event
+// listeners. It's implemented by adapters for tracing libraries, such
+// as one wrapping an OpenTelemetry trace.Tracer, so that the client
+// doesn't depend on them directly.
+type Tracer interface {
+	// Start begins a span as a child of any span in ctx, returning
+	// a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single operation traced by a Tracer.
+type Span interface {
+	// SetAttribute records a property of the operation, such as
+	// the request path. Values are strings or ints.
+	SetAttribute(key string, value any)
+
+	// AddEvent records that something happened during the
+	// operation, such as a reconnection.
+	AddEvent(name string)
+
+	// RecordError records that the operation failed.
+	RecordError(err error)
+
+	// End marks the operation as finished.
+	End()
+}
+
+// Attribute keys set on spans, following the OpenTelemetry
+// semantic conventions for HTTP.
+const (
+	attrMethod     = "http.request.method"
+	attrPath       = "url.path"
+	attrStatusCode = "http.response.status_code"
+)
+
+// noopSpan is used for event listeners when there's no tracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) AddEvent(string)          {}
+func (noopSpan) RecordError(error)        {}
+func (noopSpan) End()                     {}
+
+// startSpan starts a span with the client's tracer, if it has one.
+func (g *GuestClient) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if g.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return g.tracer.Start(ctx, name)
+}
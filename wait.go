@@ -0,0 +1,188 @@
+package guest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shellhazard/incus-guestapi/incus"
+)
+
+// waitPollInterval is how often config is polled while waiting
+// if the events API can't be used.
+const waitPollInterval = time.Second
+
+// WaitForConfig blocks until the specified config key is set,
+// returning its value, or until ctx is done. Keys are prefixed
+// in the same way as Config.
+//
+// Config events are used to detect changes, falling back to
+// polling if the events API is unavailable.
+func (g *GuestClient) WaitForConfig(ctx context.Context, key string) (string, error) {
+	return g.waitForConfig(ctx, key, func(string) bool {
+		return true
+	})
+}
+
+// WaitForConfigValue is like WaitForConfig, but blocks until
+// the key is set to want.
+func (g *GuestClient) WaitForConfigValue(ctx context.Context, key, want string) error {
+	_, err := g.waitForConfig(ctx, key, func(value string) bool {
+		return value == want
+	})
+	return err
+}
+
+// waitForConfig blocks until key is set to a value accepted
+// by match, or until ctx is done.
+func (g *GuestClient) waitForConfig(ctx context.Context, key string, match func(string) bool) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	formattedKey := g.configKey(key)
+
+	// Subscribe before the first check so no change is missed
+	// between the two.
+	evc, errc, err := g.events(ctx, listenHooks{}, incus.EventTypeConfig)
+	if err != nil {
+		g.logger.Debug("events unavailable, polling config", "key", formattedKey, "error", err)
+	}
+
+	check := func() (string, bool, error) {
+		value, err := g.configValue(ctx, formattedKey)
+		if errors.Is(err, ErrConfigKeyNotFound) {
+			return "", false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+
+		return value, match(value), nil
+	}
+
+	var tick <-chan time.Time
+	if evc == nil {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		value, ok, err := check()
+		if err != nil || ok {
+			return value, err
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-tick:
+				break wait
+			case ev, ok := <-evc:
+				if !ok {
+					g.logger.Debug("events stream ended, polling config", "key", formattedKey, "error", <-errc)
+
+					evc = nil
+					ticker := time.NewTicker(waitPollInterval)
+					defer ticker.Stop()
+					tick = ticker.C
+					continue
+				}
+
+				if ev.Config.Key == formattedKey {
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// WatchConfig returns a channel receiving each change to the specified
+// config key, as reported by config events. Keys are prefixed in the
+// same way as Config. The channel is closed once ctx is done or the
+// events connection fails, so use WithReconnect for long-lived watches.
+//
+// An error is returned directly if the connection can't be opened.
+func (g *GuestClient) WatchConfig(ctx context.Context, key string) (<-chan incus.ConfigUpdateMetadata, error) {
+	formattedKey := g.configKey(key)
+	if err := validateConfigKey(formattedKey); err != nil {
+		return nil, err
+	}
+
+	evc, errc, err := g.events(ctx, listenHooks{}, incus.EventTypeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan incus.ConfigUpdateMetadata)
+
+	go func() {
+		defer close(out)
+
+		for ev := range evc {
+			if ev.Config.Key != formattedKey {
+				continue
+			}
+
+			select {
+			case out <- ev.Config:
+			case <-ctx.Done():
+			}
+		}
+
+		if err := <-errc; err != nil {
+			g.logger.Debug("config watch ended", "key", formattedKey, "error", err)
+		}
+	}()
+
+	return out, nil
+}
+
+// WaitForState polls the instance info every pollInterval until its
+// state matches target, or until ctx is done. If pollInterval isn't
+// positive, it defaults to one second.
+//
+// States are compared case-insensitively, and since a running instance
+// may be reported as either "Running" or "Started" depending on its
+// type, those two are treated as the same.
+func (g *GuestClient) WaitForState(ctx context.Context, target string, pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = waitPollInterval
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := g.InfoContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if sameState(info.State, target) {
+			return nil
+		}
+
+		g.logger.Debug("waiting for instance state", "state", info.State, "target", target)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sameState reports whether two instance states are equivalent.
+func sameState(a, b string) bool {
+	normalize := func(state string) string {
+		if strings.EqualFold(state, string(incus.StateStarted)) {
+			return string(incus.StateRunning)
+		}
+		return state
+	}
+
+	return strings.EqualFold(normalize(a), normalize(b))
+}